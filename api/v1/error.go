@@ -3,6 +3,7 @@ package log_v1
 import (
 	"fmt"
 	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
@@ -24,3 +25,132 @@ func (e ErrOffsetOutOfRange) GRPCStatus() *status.Status {
 func (e ErrOffsetOutOfRange) Error() string {
 	return e.GRPCStatus().Err().Error()
 }
+
+type ErrCorruptRecord struct {
+	Offset  uint64
+	Segment uint64
+}
+
+func (e ErrCorruptRecord) GRPCStatus() *status.Status {
+	st := status.New(codes.DataLoss, fmt.Sprintf("corrupt record: %d", e.Offset))
+	msg := fmt.Sprintf("The record at offset %d in segment %d failed its checksum", e.Offset, e.Segment)
+	d := &errdetails.LocalizedMessage{Locale: "en-US", Message: msg}
+	std, err := st.WithDetails(d)
+	if err != nil {
+		return st
+	}
+	return std
+}
+
+func (e ErrCorruptRecord) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+type ErrTopicNotFound struct {
+	Name string
+}
+
+func (e ErrTopicNotFound) GRPCStatus() *status.Status {
+	return status.New(codes.NotFound, fmt.Sprintf("topic not found: %s", e.Name))
+}
+
+func (e ErrTopicNotFound) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+type ErrTopicExists struct {
+	Name string
+}
+
+func (e ErrTopicExists) GRPCStatus() *status.Status {
+	return status.New(codes.AlreadyExists, fmt.Sprintf("topic already exists: %s", e.Name))
+}
+
+func (e ErrTopicExists) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+type ErrInvalidTopic struct {
+	Name string
+}
+
+func (e ErrInvalidTopic) GRPCStatus() *status.Status {
+	return status.New(codes.InvalidArgument, fmt.Sprintf("invalid topic name: %q", e.Name))
+}
+
+func (e ErrInvalidTopic) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+type ErrPartitionNotFound struct {
+	Topic     string
+	Partition uint32
+}
+
+func (e ErrPartitionNotFound) GRPCStatus() *status.Status {
+	return status.New(codes.NotFound, fmt.Sprintf("partition not found: %s/%d", e.Topic, e.Partition))
+}
+
+func (e ErrPartitionNotFound) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+type ErrNoCommittedOffset struct {
+	Group     string
+	Topic     string
+	Partition uint32
+}
+
+func (e ErrNoCommittedOffset) GRPCStatus() *status.Status {
+	return status.New(codes.NotFound, fmt.Sprintf("no committed offset: group %s on %s/%d", e.Group, e.Topic, e.Partition))
+}
+
+func (e ErrNoCommittedOffset) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+type ErrUnknownMember struct {
+	Group    string
+	MemberID string
+}
+
+func (e ErrUnknownMember) GRPCStatus() *status.Status {
+	return status.New(codes.NotFound, fmt.Sprintf("unknown member %s of group %s, rejoin", e.MemberID, e.Group))
+}
+
+func (e ErrUnknownMember) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+type ErrInvalidStrategy struct {
+	Group    string
+	Strategy string
+}
+
+func (e ErrInvalidStrategy) GRPCStatus() *status.Status {
+	return status.New(codes.InvalidArgument, fmt.Sprintf("invalid assignment strategy %q for group %s", e.Strategy, e.Group))
+}
+
+func (e ErrInvalidStrategy) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+type ErrOffsetMismatch struct {
+	Expected uint64
+	Offset   uint64
+}
+
+func (e ErrOffsetMismatch) GRPCStatus() *status.Status {
+	st := status.New(codes.FailedPrecondition, fmt.Sprintf("offset mismatch: %d, expected %d", e.Offset, e.Expected))
+	msg := fmt.Sprintf("The record at offset %d does not continue the log, the next offset is %d", e.Offset, e.Expected)
+	d := &errdetails.LocalizedMessage{Locale: "en-US", Message: msg}
+	std, err := st.WithDetails(d)
+	if err != nil {
+		return st
+	}
+	return std
+}
+
+func (e ErrOffsetMismatch) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
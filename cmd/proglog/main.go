@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	log_v1 "github.com/mishamolnar/proglog/api/v1"
+	"github.com/mishamolnar/proglog/internal/mirror"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+)
+
+const usage = `usage: proglog <command> [flags]
+
+commands:
+  mirror    copy a partition to another server keeping its offsets
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	switch os.Args[1] {
+	case "mirror":
+		runMirror(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+func runMirror(args []string) {
+	fs := flag.NewFlagSet("mirror", flag.ExitOnError)
+	source := fs.String("source", "", "address of the server to copy from")
+	destination := fs.String("destination", "", "address of the server to copy to, it must allow AppendAt")
+	topic := fs.String("topic", "", "topic to copy, the default log when empty")
+	partition := fs.Uint("partition", 0, "partition of the topic to copy")
+	batch := fs.Int("batch", 100, "most records sent to the destination at once")
+	interval := fs.Duration("report", 5*time.Second, "how often the lag is reported")
+	fs.Parse(args)
+	if *source == "" || *destination == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	src, err := dial(*source)
+	if err != nil {
+		log.Fatal(err)
+	}
+	dst, err := dial(*destination)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	m := &mirror.Mirror{
+		Source:         src,
+		Destination:    dst,
+		Topic:          *topic,
+		Partition:      uint32(*partition),
+		BatchSize:      *batch,
+		ReportInterval: *interval,
+		Report: func(p mirror.Progress) {
+			log.Printf("mirrored up to %d, source at %d, lag %d", p.MirroredNext, p.SourceNext, p.Lag())
+		},
+	}
+	if err = m.Run(ctx); err != nil && ctx.Err() == nil {
+		log.Fatal(err)
+	}
+}
+
+func dial(addr string) (log_v1.LogClient, error) {
+	cc, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	return log_v1.NewLogClient(cc), nil
+}
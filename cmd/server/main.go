@@ -1,11 +1,29 @@
 package main
 
 import (
+	"github.com/mishamolnar/proglog/internal/group"
+	proglog "github.com/mishamolnar/proglog/internal/log"
 	"github.com/mishamolnar/proglog/internal/server"
 	"log"
+	"os"
 )
 
 func main() {
-	srv := server.NewHTTPServer(":8080")
+	if err := os.MkdirAll("/tmp/logs", 0755); err != nil {
+		log.Fatal(err)
+	}
+	clog, err := proglog.NewLog("/tmp/logs", proglog.Config{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	topics, err := proglog.NewTopicManager("/tmp/topics", proglog.Config{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	offsets, err := group.NewOffsets("/tmp/offsets", proglog.Config{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv := server.NewHTTPServer(":8080", &server.Config{CommitLog: clog, Topics: topics, Offsets: offsets})
 	log.Fatal(srv.ListenAndServe())
 }
@@ -0,0 +1,151 @@
+package discovery
+
+import (
+	"fmt"
+	"github.com/hashicorp/serf/serf"
+	"log"
+	"net"
+	"os"
+	"strconv"
+)
+
+// RPCAddrTag is the tag every member advertises the address of its RPC server under
+const RPCAddrTag = "rpc_addr"
+
+// Handler is told when members join and leave the cluster, it never hears about the local member
+type Handler interface {
+	Join(name, addr string) error
+	Leave(name string) error
+}
+
+type Config struct {
+	NodeName string
+	// BindAddr is the host:port gossip listens on
+	BindAddr string
+	// Tags are shared with the other members, RPCAddrTag must be among them
+	Tags map[string]string
+	// StartJoinAddrs are gossip addresses of existing members, empty starts a new cluster
+	StartJoinAddrs []string
+}
+
+// Membership gossips with the other members of the cluster and passes every join and leave to its handler.
+// Members that stop answering are reported as left once Serf declares them failed
+type Membership struct {
+	Config
+	handler Handler
+	serf    *serf.Serf
+	events  chan serf.Event
+	done    chan struct{} //closed once Serf is shut down to stop the event handler
+	logger  *log.Logger
+}
+
+func New(handler Handler, config Config) (*Membership, error) {
+	if _, ok := config.Tags[RPCAddrTag]; !ok {
+		return nil, fmt.Errorf("member %s has no %s tag", config.NodeName, RPCAddrTag)
+	}
+	m := &Membership{
+		Config:  config,
+		handler: handler,
+		logger:  log.New(os.Stderr, "membership: ", log.LstdFlags),
+	}
+	if err := m.setupSerf(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *Membership) setupSerf() error {
+	host, port, err := net.SplitHostPort(m.BindAddr)
+	if err != nil {
+		return err
+	}
+	config := serf.DefaultConfig()
+	config.Init()
+	config.MemberlistConfig.BindAddr = host
+	config.MemberlistConfig.BindPort, err = strconv.Atoi(port)
+	if err != nil {
+		return err
+	}
+	m.events = make(chan serf.Event)
+	m.done = make(chan struct{})
+	config.EventCh = m.events
+	config.Tags = m.Tags
+	config.NodeName = m.NodeName
+	m.serf, err = serf.Create(config)
+	if err != nil {
+		return err
+	}
+	go m.eventHandler()
+	if len(m.StartJoinAddrs) > 0 {
+		if _, err = m.serf.Join(m.StartJoinAddrs, true); err != nil {
+			m.serf.Shutdown()
+			close(m.done)
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Membership) eventHandler() {
+	for {
+		var e serf.Event
+		select {
+		case e = <-m.events:
+		case <-m.done:
+			return
+		}
+		switch e.EventType() {
+		case serf.EventMemberJoin:
+			for _, member := range e.(serf.MemberEvent).Members {
+				if m.isLocal(member) {
+					continue
+				}
+				m.handleJoin(member)
+			}
+		case serf.EventMemberLeave, serf.EventMemberFailed:
+			for _, member := range e.(serf.MemberEvent).Members {
+				if m.isLocal(member) {
+					continue
+				}
+				m.handleLeave(member)
+			}
+		}
+	}
+}
+
+func (m *Membership) handleJoin(member serf.Member) {
+	if err := m.handler.Join(member.Name, member.Tags[RPCAddrTag]); err != nil {
+		m.logError(err, "failed to join", member)
+	}
+}
+
+func (m *Membership) handleLeave(member serf.Member) {
+	if err := m.handler.Leave(member.Name); err != nil {
+		m.logError(err, "failed to leave", member)
+	}
+}
+
+func (m *Membership) isLocal(member serf.Member) bool {
+	return m.serf.LocalMember().Name == member.Name
+}
+
+// Members returns every member Serf knows of, including failed and left ones and the local member
+func (m *Membership) Members() []serf.Member {
+	return m.serf.Members()
+}
+
+// Leave tells the cluster this member is going away and stops gossiping
+func (m *Membership) Leave() error {
+	if err := m.serf.Leave(); err != nil {
+		return err
+	}
+	if err := m.serf.Shutdown(); err != nil {
+		return err
+	}
+	close(m.done)
+	return nil
+}
+
+func (m *Membership) logError(err error, msg string, member serf.Member) {
+	m.logger.Printf("%s: %s (%s): %v", msg, member.Name, member.Tags[RPCAddrTag], err)
+}
@@ -0,0 +1,116 @@
+package discovery
+
+import (
+	"fmt"
+	"github.com/hashicorp/serf/serf"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMembership(t *testing.T) {
+	m, handler := setupMember(t, nil)
+	m, _ = setupMember(t, m)
+	m, _ = setupMember(t, m)
+
+	require.Eventually(t, func() bool {
+		return len(handler.joins) == 2 && len(m[0].Members()) == 3 && len(handler.leaves) == 0
+	}, 3*time.Second, 250*time.Millisecond)
+	joined := map[string]string{}
+	for i := 0; i < 2; i++ {
+		j := <-handler.joins
+		joined[j["id"]] = j["addr"]
+	}
+	require.Equal(t, map[string]string{"1": m[1].Tags[RPCAddrTag], "2": m[2].Tags[RPCAddrTag]}, joined)
+
+	require.NoError(t, m[2].Leave())
+	require.Eventually(t, func() bool {
+		return len(handler.leaves) == 1 && memberStatus(m[0], "2") == serf.StatusLeft
+	}, 3*time.Second, 250*time.Millisecond)
+	require.Equal(t, "2", <-handler.leaves)
+
+	//the member that left no longer takes events
+	select {
+	case m[2].events <- serf.MemberEvent{Type: serf.EventMemberJoin}:
+		t.Fatal("event handler still running after Leave")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestMembershipFailure(t *testing.T) {
+	m, handler := setupMember(t, nil)
+	m, _ = setupMember(t, m)
+	require.Eventually(t, func() bool {
+		return len(handler.joins) == 1
+	}, 3*time.Second, 250*time.Millisecond)
+
+	//a member that stops without leaving is detected by probing
+	require.NoError(t, m[1].serf.Shutdown())
+	require.Eventually(t, func() bool {
+		return len(handler.leaves) == 1 && memberStatus(m[0], "1") == serf.StatusFailed
+	}, 20*time.Second, 250*time.Millisecond)
+	require.Equal(t, "1", <-handler.leaves)
+}
+
+func TestMembershipRequiresRPCAddr(t *testing.T) {
+	_, err := New(&handler{}, Config{NodeName: "0", BindAddr: freeAddr(t)})
+	require.Error(t, err)
+}
+
+func setupMember(t *testing.T, members []*Membership) ([]*Membership, *handler) {
+	t.Helper()
+	id := len(members)
+	c := Config{
+		NodeName: fmt.Sprintf("%d", id),
+		BindAddr: freeAddr(t),
+		Tags:     map[string]string{RPCAddrTag: freeAddr(t)},
+	}
+	h := &handler{}
+	if len(members) == 0 {
+		h.joins = make(chan map[string]string, 3)
+		h.leaves = make(chan string, 3)
+	} else {
+		c.StartJoinAddrs = []string{members[0].BindAddr}
+	}
+	m, err := New(h, c)
+	require.NoError(t, err)
+	t.Cleanup(func() { m.Leave() })
+	return append(members, m), h
+}
+
+func memberStatus(m *Membership, name string) serf.MemberStatus {
+	for _, member := range m.Members() {
+		if member.Name == name {
+			return member.Status
+		}
+	}
+	return serf.StatusNone
+}
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+type handler struct {
+	joins  chan map[string]string
+	leaves chan string
+}
+
+func (h *handler) Join(id, addr string) error {
+	if h.joins != nil {
+		h.joins <- map[string]string{"id": id, "addr": addr}
+	}
+	return nil
+}
+
+func (h *handler) Leave(id string) error {
+	if h.leaves != nil {
+		h.leaves <- id
+	}
+	return nil
+}
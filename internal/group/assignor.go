@@ -0,0 +1,175 @@
+package group
+
+import (
+	"sort"
+)
+
+type TopicPartition struct {
+	Topic     string
+	Partition uint32
+}
+
+func (tp TopicPartition) less(o TopicPartition) bool {
+	if tp.Topic != o.Topic {
+		return tp.Topic < o.Topic
+	}
+	return tp.Partition < o.Partition
+}
+
+// Member is a consumer in a group, Topics maps each topic it subscribes to onto the topic's partition count
+type Member struct {
+	ID     string
+	Topics map[string]int
+}
+
+func (m Member) subscribes(topic string) bool {
+	_, ok := m.Topics[topic]
+	return ok
+}
+
+// Assignor divides the partitions the members subscribe to among them. Members are sorted by ID and previous
+// is the assignment of the last generation, members missing from the result get no partitions
+type Assignor interface {
+	Name() string
+	Assign(members []Member, previous map[string][]TopicPartition) map[string][]TopicPartition
+}
+
+var (
+	_ Assignor = RangeAssignor{}
+	_ Assignor = RoundRobinAssignor{}
+	_ Assignor = StickyAssignor{}
+)
+
+// partitions lists every partition of the topics the members subscribe to, in order
+func partitions(members []Member) []TopicPartition {
+	counts := make(map[string]int)
+	for _, m := range members {
+		for topic, n := range m.Topics {
+			counts[topic] = max(counts[topic], n)
+		}
+	}
+	var tps []TopicPartition
+	for topic, n := range counts {
+		for p := 0; p < n; p++ {
+			tps = append(tps, TopicPartition{Topic: topic, Partition: uint32(p)})
+		}
+	}
+	sort.Slice(tps, func(i, j int) bool {
+		return tps[i].less(tps[j])
+	})
+	return tps
+}
+
+// RangeAssignor gives each member of a topic a contiguous range of its partitions, the first members get one
+// extra when they do not divide evenly
+type RangeAssignor struct{}
+
+func (RangeAssignor) Name() string {
+	return "range"
+}
+
+func (RangeAssignor) Assign(members []Member, previous map[string][]TopicPartition) map[string][]TopicPartition {
+	assignment := make(map[string][]TopicPartition)
+	byTopic := make(map[string][]TopicPartition)
+	for _, tp := range partitions(members) {
+		byTopic[tp.Topic] = append(byTopic[tp.Topic], tp)
+	}
+	for topic, tps := range byTopic {
+		var subscribed []Member
+		for _, m := range members {
+			if m.subscribes(topic) {
+				subscribed = append(subscribed, m)
+			}
+		}
+		per, extra := len(tps)/len(subscribed), len(tps)%len(subscribed)
+		for i, m := range subscribed {
+			n := per
+			if i < extra {
+				n++
+			}
+			assignment[m.ID] = append(assignment[m.ID], tps[:n]...)
+			tps = tps[n:]
+		}
+	}
+	for id := range assignment {
+		sortPartitions(assignment[id])
+	}
+	return assignment
+}
+
+// RoundRobinAssignor deals the partitions of all topics out one at a time, skipping members not subscribed
+type RoundRobinAssignor struct{}
+
+func (RoundRobinAssignor) Name() string {
+	return "roundrobin"
+}
+
+func (RoundRobinAssignor) Assign(members []Member, previous map[string][]TopicPartition) map[string][]TopicPartition {
+	assignment := make(map[string][]TopicPartition)
+	next := 0
+	for _, tp := range partitions(members) {
+		for i := 0; i < len(members); i++ {
+			m := members[(next+i)%len(members)]
+			if m.subscribes(tp.Topic) {
+				assignment[m.ID] = append(assignment[m.ID], tp)
+				next = (next + i + 1) % len(members)
+				break
+			}
+		}
+	}
+	return assignment
+}
+
+// StickyAssignor keeps partitions with the members that had them as long as that stays balanced, so a
+// rebalance moves as few partitions as it can
+type StickyAssignor struct{}
+
+func (StickyAssignor) Name() string {
+	return "sticky"
+}
+
+func (StickyAssignor) Assign(members []Member, previous map[string][]TopicPartition) map[string][]TopicPartition {
+	all := partitions(members)
+	limit := (len(all) + len(members) - 1) / len(members)
+	exists := make(map[TopicPartition]bool, len(all))
+	for _, tp := range all {
+		exists[tp] = true
+	}
+	assignment := make(map[string][]TopicPartition)
+	taken := make(map[TopicPartition]bool)
+	for _, m := range members {
+		for _, tp := range previous[m.ID] {
+			if len(assignment[m.ID]) == limit {
+				break
+			}
+			if exists[tp] && !taken[tp] && m.subscribes(tp.Topic) {
+				assignment[m.ID] = append(assignment[m.ID], tp)
+				taken[tp] = true
+			}
+		}
+	}
+	for _, tp := range all {
+		if taken[tp] {
+			continue
+		}
+		var least *Member
+		for i, m := range members {
+			if m.subscribes(tp.Topic) && (least == nil || len(assignment[m.ID]) < len(assignment[least.ID])) {
+				least = &members[i]
+			}
+		}
+		if least != nil {
+			assignment[least.ID] = append(assignment[least.ID], tp)
+		}
+	}
+	for id := range assignment {
+		sortPartitions(assignment[id])
+	}
+	return assignment
+}
+
+func sortPartitions(tps []TopicPartition) {
+	sort.Slice(tps, func(i, j int) bool {
+		return tps[i].less(tps[j])
+	})
+}
@@ -0,0 +1,68 @@
+package group
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func tps(topic string, partitions ...uint32) []TopicPartition {
+	res := make([]TopicPartition, len(partitions))
+	for i, p := range partitions {
+		res[i] = TopicPartition{Topic: topic, Partition: p}
+	}
+	return res
+}
+
+func TestRangeAssignor(t *testing.T) {
+	members := []Member{
+		{ID: "a", Topics: map[string]int{"orders": 5, "payments": 2}},
+		{ID: "b", Topics: map[string]int{"orders": 5, "payments": 2}},
+	}
+	got := RangeAssignor{}.Assign(members, nil)
+	require.Equal(t, map[string][]TopicPartition{
+		"a": append(tps("orders", 0, 1, 2), tps("payments", 0)...),
+		"b": append(tps("orders", 3, 4), tps("payments", 1)...),
+	}, got)
+}
+
+func TestRoundRobinAssignor(t *testing.T) {
+	members := []Member{
+		{ID: "a", Topics: map[string]int{"orders": 3}},
+		{ID: "b", Topics: map[string]int{"orders": 3, "payments": 2}},
+		{ID: "c", Topics: map[string]int{"orders": 3}},
+	}
+	got := RoundRobinAssignor{}.Assign(members, nil)
+	//only b subscribes to payments
+	require.Equal(t, map[string][]TopicPartition{
+		"a": tps("orders", 0),
+		"b": append(tps("orders", 1), tps("payments", 0, 1)...),
+		"c": tps("orders", 2),
+	}, got)
+}
+
+func TestStickyAssignor(t *testing.T) {
+	topics := map[string]int{"orders": 6}
+	three := []Member{{ID: "a", Topics: topics}, {ID: "b", Topics: topics}, {ID: "c", Topics: topics}}
+	first := StickyAssignor{}.Assign(three, nil)
+	for _, m := range three {
+		require.Len(t, first[m.ID], 2)
+	}
+
+	//when c dies only its partitions move
+	second := StickyAssignor{}.Assign(three[:2], first)
+	for _, id := range []string{"a", "b"} {
+		require.Len(t, second[id], 3)
+		require.Subset(t, second[id], first[id])
+	}
+
+	//a new member takes partitions off the others without shuffling the rest
+	four := append(three[:2:2], Member{ID: "d", Topics: topics})
+	third := StickyAssignor{}.Assign(four, second)
+	moved := 0
+	for _, id := range []string{"a", "b"} {
+		require.Subset(t, second[id], third[id])
+		moved += len(second[id]) - len(third[id])
+	}
+	require.Len(t, third["d"], moved)
+	require.Equal(t, 2, moved)
+}
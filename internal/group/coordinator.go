@@ -0,0 +1,175 @@
+package group
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	log_v1 "github.com/mishamolnar/proglog/api/v1"
+	"sort"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	// SessionTimeout is how long a member lives without a heartbeat unless it asks for its own, 10s when zero
+	SessionTimeout time.Duration
+	// Assignors are strategies members can ask for on top of range, roundrobin and sticky
+	Assignors []Assignor
+	// Now is the coordinator's clock, time.Now when nil
+	Now func() time.Time
+}
+
+// Assignment is what a member owns in a generation of its group
+type Assignment struct {
+	MemberID   string
+	Generation int64
+	Partitions []TopicPartition
+}
+
+type member struct {
+	Member
+	sessionTimeout time.Duration
+	deadline       time.Time
+}
+
+type consumerGroup struct {
+	strategy   Assignor
+	generation int64
+	members    map[string]*member
+	assignment map[string][]TopicPartition
+}
+
+// Coordinator tracks the live members of consumer groups and divides partitions among them. Members that miss
+// their session timeout are dropped on the next call touching their group, every change of membership starts a
+// new generation with a fresh assignment that the other members pick up with their next heartbeat
+type Coordinator struct {
+	mu        sync.Mutex
+	Config    Config
+	assignors map[string]Assignor
+	groups    map[string]*consumerGroup
+}
+
+func NewCoordinator(c Config) *Coordinator {
+	if c.SessionTimeout == 0 {
+		c.SessionTimeout = 10 * time.Second
+	}
+	if c.Now == nil {
+		c.Now = time.Now
+	}
+	co := &Coordinator{Config: c, assignors: make(map[string]Assignor), groups: make(map[string]*consumerGroup)}
+	for _, a := range append([]Assignor{RangeAssignor{}, RoundRobinAssignor{}, StickyAssignor{}}, c.Assignors...) {
+		co.assignors[a.Name()] = a
+	}
+	return co
+}
+
+// Join adds m to the group, or updates its subscription when its ID is already a member. An empty ID gets a
+// generated one, sessionTimeout zero uses the coordinator's. An empty strategy takes the group's, or range for a
+// new group
+func (c *Coordinator) Join(group string, m Member, strategy string, sessionTimeout time.Duration) (Assignment, error) {
+	if sessionTimeout == 0 {
+		sessionTimeout = c.Config.SessionTimeout
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	g := c.group(group)
+	if strategy == "" && g != nil {
+		strategy = g.strategy.Name()
+	} else if strategy == "" {
+		strategy = RangeAssignor{}.Name()
+	}
+	a, ok := c.assignors[strategy]
+	if !ok {
+		return Assignment{}, log_v1.ErrInvalidStrategy{Group: group, Strategy: strategy}
+	}
+	if g != nil && g.strategy.Name() != strategy {
+		return Assignment{}, log_v1.ErrInvalidStrategy{Group: group, Strategy: strategy}
+	}
+	if m.ID == "" {
+		m.ID = newMemberID()
+	} else if g == nil || g.members[m.ID] == nil {
+		return Assignment{}, log_v1.ErrUnknownMember{Group: group, MemberID: m.ID}
+	}
+	if g == nil {
+		g = &consumerGroup{strategy: a, members: make(map[string]*member)}
+		c.groups[group] = g
+	}
+	g.members[m.ID] = &member{Member: m, sessionTimeout: sessionTimeout, deadline: c.Config.Now().Add(sessionTimeout)}
+	g.rebalance()
+	return g.assignmentOf(m.ID), nil
+}
+
+// Heartbeat keeps the member's session alive and returns the assignment of the group's current generation
+func (c *Coordinator) Heartbeat(group, memberID string) (Assignment, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	g := c.group(group)
+	if g == nil || g.members[memberID] == nil {
+		return Assignment{}, log_v1.ErrUnknownMember{Group: group, MemberID: memberID}
+	}
+	m := g.members[memberID]
+	m.deadline = c.Config.Now().Add(m.sessionTimeout)
+	return g.assignmentOf(memberID), nil
+}
+
+func (c *Coordinator) Leave(group, memberID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	g := c.group(group)
+	if g == nil || g.members[memberID] == nil {
+		return log_v1.ErrUnknownMember{Group: group, MemberID: memberID}
+	}
+	delete(g.members, memberID)
+	c.settle(group, g)
+	return nil
+}
+
+// group returns the named group after dropping its expired members, nil when it has none left
+func (c *Coordinator) group(name string) *consumerGroup {
+	g, ok := c.groups[name]
+	if !ok {
+		return nil
+	}
+	now := c.Config.Now()
+	expired := false
+	for id, m := range g.members {
+		if !now.Before(m.deadline) {
+			delete(g.members, id)
+			expired = true
+		}
+	}
+	if expired {
+		c.settle(name, g)
+	}
+	return c.groups[name]
+}
+
+// settle rebalances a group that lost members, forgetting it once it is empty
+func (c *Coordinator) settle(name string, g *consumerGroup) {
+	if len(g.members) == 0 {
+		delete(c.groups, name)
+		return
+	}
+	g.rebalance()
+}
+
+func (g *consumerGroup) rebalance() {
+	members := make([]Member, 0, len(g.members))
+	for _, m := range g.members {
+		members = append(members, m.Member)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].ID < members[j].ID
+	})
+	g.assignment = g.strategy.Assign(members, g.assignment)
+	g.generation++
+}
+
+func (g *consumerGroup) assignmentOf(memberID string) Assignment {
+	return Assignment{MemberID: memberID, Generation: g.generation, Partitions: g.assignment[memberID]}
+}
+
+func newMemberID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,112 @@
+package group
+
+import (
+	log_v1 "github.com/mishamolnar/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestCoordinator(t *testing.T) {
+	for scenario, fn := range map[string]func(t *testing.T, c *Coordinator, clock *time.Time){
+		"members share partitions and rebalance on join": testCoordinatorJoin,
+		"members missing heartbeats are dropped":         testCoordinatorExpiry,
+		"leaving hands partitions over":                  testCoordinatorLeave,
+		"strategies must be known and agreed on":         testCoordinatorStrategy,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			clock := time.Unix(1700000000, 0)
+			c := NewCoordinator(Config{SessionTimeout: 10 * time.Second, Now: func() time.Time { return clock }})
+			fn(t, c, &clock)
+		})
+	}
+}
+
+var orders = map[string]int{"orders": 4}
+
+func testCoordinatorJoin(t *testing.T, c *Coordinator, clock *time.Time) {
+	a, err := c.Join("workers", Member{Topics: orders}, "", 0)
+	require.NoError(t, err)
+	require.NotEmpty(t, a.MemberID)
+	require.Equal(t, int64(1), a.Generation)
+	require.Len(t, a.Partitions, 4)
+
+	b, err := c.Join("workers", Member{Topics: orders}, "", 0)
+	require.NoError(t, err)
+	require.NotEqual(t, a.MemberID, b.MemberID)
+	require.Equal(t, int64(2), b.Generation)
+	require.Len(t, b.Partitions, 2)
+
+	//a learns about the rebalance from its next heartbeat
+	a, err = c.Heartbeat("workers", a.MemberID)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), a.Generation)
+	require.Len(t, a.Partitions, 2)
+	require.NotSubset(t, a.Partitions, b.Partitions)
+
+	//other groups get their own copy of every partition
+	other, err := c.Join("auditors", Member{Topics: orders}, "", 0)
+	require.NoError(t, err)
+	require.Len(t, other.Partitions, 4)
+}
+
+func testCoordinatorExpiry(t *testing.T, c *Coordinator, clock *time.Time) {
+	a, err := c.Join("workers", Member{Topics: orders}, "", 0)
+	require.NoError(t, err)
+	b, err := c.Join("workers", Member{Topics: orders}, "", 30*time.Second)
+	require.NoError(t, err)
+
+	*clock = clock.Add(8 * time.Second)
+	_, err = c.Heartbeat("workers", a.MemberID)
+	require.NoError(t, err)
+
+	//b asked for a longer session and outlives a once a stops heartbeating
+	*clock = clock.Add(10 * time.Second)
+	b, err = c.Heartbeat("workers", b.MemberID)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), b.Generation)
+	require.Len(t, b.Partitions, 4)
+	_, err = c.Heartbeat("workers", a.MemberID)
+	require.Equal(t, log_v1.ErrUnknownMember{Group: "workers", MemberID: a.MemberID}, err)
+	_, err = c.Join("workers", Member{ID: a.MemberID, Topics: orders}, "", 0)
+	require.Equal(t, log_v1.ErrUnknownMember{Group: "workers", MemberID: a.MemberID}, err)
+}
+
+func testCoordinatorLeave(t *testing.T, c *Coordinator, clock *time.Time) {
+	a, err := c.Join("workers", Member{Topics: orders}, "sticky", 0)
+	require.NoError(t, err)
+	b, err := c.Join("workers", Member{Topics: orders}, "sticky", 0)
+	require.NoError(t, err)
+	require.NoError(t, c.Leave("workers", b.MemberID))
+	require.Equal(t, log_v1.ErrUnknownMember{Group: "workers", MemberID: b.MemberID}, c.Leave("workers", b.MemberID))
+	a, err = c.Heartbeat("workers", a.MemberID)
+	require.NoError(t, err)
+	require.Len(t, a.Partitions, 4)
+
+	//an emptied group is forgotten and may come back with another strategy
+	require.NoError(t, c.Leave("workers", a.MemberID))
+	_, err = c.Join("workers", Member{Topics: orders}, "roundrobin", 0)
+	require.NoError(t, err)
+
+	//a rejected join leaves no group behind
+	_, err = c.Join("auditors", Member{ID: "ghost", Topics: orders}, "sticky", 0)
+	require.Equal(t, log_v1.ErrUnknownMember{Group: "auditors", MemberID: "ghost"}, err)
+	_, err = c.Join("auditors", Member{Topics: orders}, "range", 0)
+	require.NoError(t, err)
+}
+
+func testCoordinatorStrategy(t *testing.T, c *Coordinator, clock *time.Time) {
+	_, err := c.Join("workers", Member{Topics: orders}, "random", 0)
+	require.Equal(t, log_v1.ErrInvalidStrategy{Group: "workers", Strategy: "random"}, err)
+	_, err = c.Join("workers", Member{Topics: orders}, "range", 0)
+	require.NoError(t, err)
+	_, err = c.Join("workers", Member{Topics: orders}, "sticky", 0)
+	require.Equal(t, log_v1.ErrInvalidStrategy{Group: "workers", Strategy: "sticky"}, err)
+
+	//no strategy joins an existing group with its own
+	_, err = c.Join("pickers", Member{Topics: orders}, "sticky", 0)
+	require.NoError(t, err)
+	_, err = c.Join("pickers", Member{Topics: orders}, "", 0)
+	require.NoError(t, err)
+	require.Equal(t, "sticky", c.group("pickers").strategy.Name())
+}
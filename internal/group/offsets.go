@@ -0,0 +1,117 @@
+package group
+
+import (
+	"encoding/binary"
+	"fmt"
+	log_v1 "github.com/mishamolnar/proglog/api/v1"
+	"github.com/mishamolnar/proglog/internal/log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Key names the partition a group commits offsets for
+type Key struct {
+	Group     string
+	Topic     string
+	Partition uint32
+}
+
+// the group goes last since it is the only part that may contain '/'
+func (k Key) bytes() []byte {
+	return []byte(k.Topic + "/" + strconv.FormatUint(uint64(k.Partition), 10) + "/" + k.Group)
+}
+
+func parseKey(b []byte) (Key, error) {
+	parts := strings.SplitN(string(b), "/", 3)
+	if len(parts) != 3 {
+		return Key{}, fmt.Errorf("malformed offset key %q", b)
+	}
+	p, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return Key{}, fmt.Errorf("malformed offset key %q: %w", b, err)
+	}
+	return Key{Topic: parts[0], Partition: uint32(p), Group: parts[2]}, nil
+}
+
+// Offsets keeps the offsets consumer groups committed, each commit is a record in a Log keyed by Key so
+// compaction keeps only the latest one
+type Offsets struct {
+	mu        sync.RWMutex
+	log       *log.Log
+	committed map[Key]uint64
+}
+
+func NewOffsets(dir string, c log.Config) (*Offsets, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	l, err := log.NewLog(dir, c)
+	if err != nil {
+		return nil, err
+	}
+	o := &Offsets{log: l, committed: make(map[Key]uint64)}
+	//superseded commits are dropped on every start so restarts keep the log small
+	if _, err = l.Compact(); err != nil {
+		l.Close()
+		return nil, err
+	}
+	if err = o.load(); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return o, nil
+}
+
+func (o *Offsets) load() error {
+	off, err := o.log.LowestOffset()
+	if err != nil {
+		return err
+	}
+	for {
+		rec, err := o.log.Read(off)
+		if _, ok := err.(log_v1.ErrOffsetOutOfRange); ok {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		k, err := parseKey(rec.Key)
+		if err != nil {
+			return err
+		}
+		if len(rec.Value) != 8 {
+			return fmt.Errorf("malformed committed offset at %d", rec.Offset)
+		}
+		o.committed[k] = binary.BigEndian.Uint64(rec.Value)
+		off = rec.Offset + 1
+	}
+}
+
+func (o *Offsets) Commit(k Key, offset uint64) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	value := binary.BigEndian.AppendUint64(nil, offset)
+	if _, err := o.log.Append(&log_v1.Record{Key: k.bytes(), Value: value}); err != nil {
+		return err
+	}
+	o.committed[k] = offset
+	return nil
+}
+
+// Fetch returns the offset last committed for k, false when there is none
+func (o *Offsets) Fetch(k Key) (uint64, bool) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	off, ok := o.committed[k]
+	return off, ok
+}
+
+func (o *Offsets) Close() error {
+	return o.log.Close()
+}
+
+func (o *Offsets) Remove() error {
+	return o.log.Remove()
+}
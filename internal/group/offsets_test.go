@@ -0,0 +1,53 @@
+package group
+
+import (
+	"github.com/mishamolnar/proglog/internal/log"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+)
+
+func TestOffsets(t *testing.T) {
+	dir, err := os.MkdirTemp("", "offsets-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	var c log.Config
+	c.Segment.MaxStoreBytes = 128
+
+	o, err := NewOffsets(dir, c)
+	require.NoError(t, err)
+	orders := Key{Group: "billing/eu", Topic: "orders", Partition: 3}
+	payments := Key{Group: "billing/eu", Topic: "payments"}
+	_, ok := o.Fetch(orders)
+	require.False(t, ok)
+	for off := uint64(1); off <= 20; off++ {
+		require.NoError(t, o.Commit(orders, off))
+	}
+	require.NoError(t, o.Commit(payments, 7))
+	off, ok := o.Fetch(orders)
+	require.True(t, ok)
+	require.Equal(t, uint64(20), off)
+	require.NoError(t, o.Close())
+
+	//commits survive a restart, the compaction on open keeps only the latest ones
+	o, err = NewOffsets(dir, c)
+	require.NoError(t, err)
+	defer o.Remove()
+	off, ok = o.Fetch(orders)
+	require.True(t, ok)
+	require.Equal(t, uint64(20), off)
+	off, ok = o.Fetch(payments)
+	require.True(t, ok)
+	require.Equal(t, uint64(7), off)
+	_, ok = o.Fetch(Key{Group: "other", Topic: "orders", Partition: 3})
+	require.False(t, ok)
+}
+
+func TestKey(t *testing.T) {
+	k := Key{Group: "a/b/c", Topic: "orders", Partition: 12}
+	parsed, err := parseKey(k.bytes())
+	require.NoError(t, err)
+	require.Equal(t, k, parsed)
+	_, err = parseKey([]byte("orders/x/group"))
+	require.Error(t, err)
+}
@@ -0,0 +1,79 @@
+package loadbalance
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/raft"
+	log_v1 "github.com/mishamolnar/proglog/api/v1"
+	"github.com/mishamolnar/proglog/internal/log"
+	"github.com/mishamolnar/proglog/internal/server"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestDistributedCluster balances a client over distributed logs that share their Raft port with the RPC server
+func TestDistributedCluster(t *testing.T) {
+	var addrs []string
+	var logs []*log.DistributedLog
+	for i := 0; i < 3; i++ {
+		dir, err := os.MkdirTemp("", "loadbalance-cluster-test")
+		require.NoError(t, err)
+		t.Cleanup(func() { os.RemoveAll(dir) })
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		mux := log.NewMux(ln)
+		go mux.Serve()
+		t.Cleanup(func() { mux.Close() })
+
+		var c log.DistributedConfig
+		c.StreamLayer = log.NewStreamLayer(mux.Raft())
+		c.Raft.LocalID = raft.ServerID(fmt.Sprintf("%d", i))
+		c.Raft.HeartbeatTimeout = 50 * time.Millisecond
+		c.Raft.ElectionTimeout = 50 * time.Millisecond
+		c.Raft.LeaderLeaseTimeout = 50 * time.Millisecond
+		c.Raft.CommitTimeout = 5 * time.Millisecond
+		c.Bootstrap = i == 0
+		l, err := log.NewDistributedLog(dir, c)
+		require.NoError(t, err)
+		t.Cleanup(func() { l.Close() })
+
+		srv, err := server.NewGRPCServer(&server.Config{CommitLog: l, ServerGetter: l})
+		require.NoError(t, err)
+		go srv.Serve(mux.Other())
+		t.Cleanup(srv.Stop)
+
+		if i == 0 {
+			require.NoError(t, l.WaitForLeader(3*time.Second))
+		} else {
+			require.NoError(t, logs[0].Join(fmt.Sprintf("%d", i), ln.Addr().String()))
+		}
+		addrs = append(addrs, ln.Addr().String())
+		logs = append(logs, l)
+	}
+
+	//any server resolves the cluster, this one is a follower
+	cc, err := grpc.Dial(fmt.Sprintf("%s:///%s", Name, addrs[1]), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer cc.Close()
+	client := log_v1.NewLogClient(cc)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	//followers reject appends, so these only succeed on the leader
+	for i := 0; i < 3; i++ {
+		res, err := client.Produce(ctx, &log_v1.ProduceRequest{Record: &log_v1.Record{Value: []byte(fmt.Sprintf("record %d", i))}}, grpc.WaitForReady(true))
+		require.NoError(t, err)
+		require.Equal(t, uint64(i), res.Offset)
+	}
+	for i := 0; i < 3; i++ {
+		require.Eventually(t, func() bool {
+			res, err := client.Consume(ctx, &log_v1.ConsumeRequest{Offset: uint64(i)})
+			return err == nil && string(res.Record.Value) == fmt.Sprintf("record %d", i)
+		}, 3*time.Second, 20*time.Millisecond)
+	}
+}
@@ -0,0 +1,62 @@
+package loadbalance
+
+import (
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"strings"
+	"sync/atomic"
+)
+
+var _ base.PickerBuilder = (*Picker)(nil)
+var _ balancer.Picker = (*Picker)(nil)
+
+// readMethods are served by any copy of the log, every other call goes to the writable server
+var readMethods = map[string]bool{
+	"Consume":       true,
+	"ConsumeStream": true,
+	"Fetch":         true,
+}
+
+// Picker sends writes to the writable server and round-robins reads over the read-only ones, reads go to the
+// writable server too while there are no others
+type Picker struct {
+	writable balancer.SubConn
+	readOnly []balancer.SubConn
+	current  atomic.Uint64
+}
+
+func init() {
+	balancer.Register(base.NewBalancerBuilder(Name, &Picker{}, base.Config{}))
+}
+
+// Build makes a picker for the connections that are ready, gRPC builds a new one whenever they change
+func (p *Picker) Build(info base.PickerBuildInfo) balancer.Picker {
+	picker := &Picker{}
+	for sc, scInfo := range info.ReadySCs {
+		if writable, _ := scInfo.Address.Attributes.Value(writableAttr).(bool); writable {
+			picker.writable = sc
+			continue
+		}
+		picker.readOnly = append(picker.readOnly, sc)
+	}
+	return picker
+}
+
+func (p *Picker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	var result balancer.PickResult
+	method := info.FullMethodName[strings.LastIndex(info.FullMethodName, "/")+1:]
+	if readMethods[method] && len(p.readOnly) > 0 {
+		result.SubConn = p.nextReadOnly()
+	} else {
+		result.SubConn = p.writable
+	}
+	if result.SubConn == nil {
+		return result, balancer.ErrNoSubConnAvailable
+	}
+	return result, nil
+}
+
+func (p *Picker) nextReadOnly() balancer.SubConn {
+	cur := p.current.Add(1) - 1
+	return p.readOnly[cur%uint64(len(p.readOnly))]
+}
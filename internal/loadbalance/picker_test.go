@@ -0,0 +1,74 @@
+package loadbalance
+
+import (
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+	"testing"
+)
+
+func TestPickerNoSubConnAvailable(t *testing.T) {
+	picker := (&Picker{}).Build(base.PickerBuildInfo{})
+	for _, method := range []string{"/log.v1.Log/Produce", "/log.v1.Log/Consume"} {
+		_, err := picker.Pick(balancer.PickInfo{FullMethodName: method})
+		require.Equal(t, balancer.ErrNoSubConnAvailable, err)
+	}
+}
+
+func TestPickerProducesToWritable(t *testing.T) {
+	picker, subConns := setupPicker(2)
+	for _, method := range []string{"/log.v1.Log/Produce", "/log.v1.Log/ProduceStream", "/log.v1.Log/Delete"} {
+		for i := 0; i < 5; i++ {
+			res, err := picker.Pick(balancer.PickInfo{FullMethodName: method})
+			require.NoError(t, err)
+			require.Equal(t, subConns[0], res.SubConn)
+		}
+	}
+}
+
+func TestPickerConsumesFromReadOnly(t *testing.T) {
+	picker, subConns := setupPicker(2)
+	seen := map[balancer.SubConn]int{}
+	for _, method := range []string{"/log.v1.Log/Consume", "/log.v1.Log/ConsumeStream"} {
+		for i := 0; i < 4; i++ {
+			res, err := picker.Pick(balancer.PickInfo{FullMethodName: method})
+			require.NoError(t, err)
+			seen[res.SubConn]++
+		}
+	}
+	require.Equal(t, map[balancer.SubConn]int{subConns[1]: 4, subConns[2]: 4}, seen)
+}
+
+func TestPickerConsumesFromWritableAlone(t *testing.T) {
+	picker, subConns := setupPicker(0)
+	res, err := picker.Pick(balancer.PickInfo{FullMethodName: "/log.v1.Log/Consume"})
+	require.NoError(t, err)
+	require.Equal(t, subConns[0], res.SubConn)
+}
+
+// setupPicker builds a picker over a writable subconn, the first returned, and readOnly others
+func setupPicker(readOnly int) (balancer.Picker, []*subConn) {
+	var subConns []*subConn
+	info := base.PickerBuildInfo{ReadySCs: make(map[balancer.SubConn]base.SubConnInfo)}
+	for i := 0; i <= readOnly; i++ {
+		sc := &subConn{}
+		addr := resolver.Address{Attributes: attributes.New(writableAttr, i == 0)}
+		sc.UpdateAddresses([]resolver.Address{addr})
+		info.ReadySCs[sc] = base.SubConnInfo{Address: addr}
+		subConns = append(subConns, sc)
+	}
+	return (&Picker{}).Build(info), subConns
+}
+
+type subConn struct {
+	balancer.SubConn
+	addrs []resolver.Address
+}
+
+func (s *subConn) UpdateAddresses(addrs []resolver.Address) {
+	s.addrs = addrs
+}
+
+func (s *subConn) Connect() {}
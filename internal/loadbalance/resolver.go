@@ -0,0 +1,88 @@
+package loadbalance
+
+import (
+	"context"
+	"fmt"
+	log_v1 "github.com/mishamolnar/proglog/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+	"log"
+	"os"
+	"sync"
+)
+
+const Name = "proglog"
+
+// writableAttr marks the address of the server that takes writes
+const writableAttr = "writable"
+
+var _ resolver.Builder = (*Resolver)(nil)
+var _ resolver.Resolver = (*Resolver)(nil)
+
+// Resolver turns a target like proglog:///host:port into every server holding the log, asking the server at
+// host:port with GetServers. Connections resolved this way are balanced by the proglog picker
+type Resolver struct {
+	mu            sync.Mutex
+	clientConn    resolver.ClientConn
+	resolverConn  *grpc.ClientConn
+	serviceConfig *serviceconfig.ParseResult
+	logger        *log.Logger
+}
+
+func init() {
+	resolver.Register(&Resolver{})
+}
+
+func (r *Resolver) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	res := &Resolver{
+		clientConn: cc,
+		logger:     log.New(os.Stderr, "resolver: ", log.LstdFlags),
+	}
+	var dialOpts []grpc.DialOption
+	if opts.DialCreds != nil {
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(opts.DialCreds))
+	}
+	res.serviceConfig = cc.ParseServiceConfig(fmt.Sprintf(`{"loadBalancingConfig":[{"%s":{}}]}`, Name))
+	var err error
+	res.resolverConn, err = grpc.Dial(target.Endpoint(), dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+	res.ResolveNow(resolver.ResolveNowOptions{})
+	return res, nil
+}
+
+func (r *Resolver) Scheme() string {
+	return Name
+}
+
+// ResolveNow asks for the current servers, gRPC calls it again when a connection fails
+func (r *Resolver) ResolveNow(resolver.ResolveNowOptions) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	client := log_v1.NewLogClient(r.resolverConn)
+	res, err := client.GetServers(context.Background(), &log_v1.GetServersRequest{})
+	if err != nil {
+		r.logger.Printf("failed to resolve servers: %v", err)
+		r.clientConn.ReportError(err)
+		return
+	}
+	var addrs []resolver.Address
+	for _, srv := range res.Servers {
+		addrs = append(addrs, resolver.Address{
+			Addr:       srv.RpcAddr,
+			Attributes: attributes.New(writableAttr, srv.Writable),
+		})
+	}
+	if err = r.clientConn.UpdateState(resolver.State{Addresses: addrs, ServiceConfig: r.serviceConfig}); err != nil {
+		r.logger.Printf("failed to update the resolved servers: %v", err)
+	}
+}
+
+func (r *Resolver) Close() {
+	if err := r.resolverConn.Close(); err != nil {
+		r.logger.Printf("failed to close the resolver connection: %v", err)
+	}
+}
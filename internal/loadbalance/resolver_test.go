@@ -0,0 +1,133 @@
+package loadbalance
+
+import (
+	"context"
+	"fmt"
+	log_v1 "github.com/mishamolnar/proglog/api/v1"
+	"github.com/mishamolnar/proglog/internal/log"
+	"github.com/mishamolnar/proglog/internal/server"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+	"net"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestResolver(t *testing.T) {
+	servers := &getServers{}
+	addr, _ := setupServer(t, servers)
+	servers.servers = []*log_v1.Server{
+		{Id: "leader", RpcAddr: addr, Writable: true},
+		{Id: "follower", RpcAddr: "localhost:9002"},
+	}
+
+	conn := &clientConn{}
+	r, err := (&Resolver{}).Build(
+		resolver.Target{URL: url.URL{Scheme: Name, Path: "/" + addr}},
+		conn,
+		resolver.BuildOptions{DialCreds: insecure.NewCredentials()},
+	)
+	require.NoError(t, err)
+	require.Equal(t, []resolver.Address{
+		{Addr: addr, Attributes: attributes.New(writableAttr, true)},
+		{Addr: "localhost:9002", Attributes: attributes.New(writableAttr, false)},
+	}, conn.state.Addresses)
+	r.Close()
+}
+
+// TestBalancedClient dials servers through the resolver, produces go to the writable one and consumes alternate
+// between the read-only ones
+func TestBalancedClient(t *testing.T) {
+	servers := &getServers{}
+	var logs []*log.Log
+	for i := 0; i < 3; i++ {
+		addr, clog := setupServer(t, servers)
+		servers.servers = append(servers.servers, &log_v1.Server{Id: fmt.Sprintf("%d", i), RpcAddr: addr, Writable: i == 0})
+		logs = append(logs, clog)
+		_, err := clog.Append(&log_v1.Record{Value: []byte(fmt.Sprintf("from %d", i))})
+		require.NoError(t, err)
+	}
+
+	cc, err := grpc.Dial(fmt.Sprintf("%s:///%s", Name, servers.servers[0].RpcAddr), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer cc.Close()
+	client := log_v1.NewLogClient(cc)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for i := 0; i < 3; i++ {
+		res, err := client.Produce(ctx, &log_v1.ProduceRequest{Record: &log_v1.Record{Value: []byte("produced")}}, grpc.WaitForReady(true))
+		require.NoError(t, err)
+		require.Equal(t, uint64(i+1), res.Offset)
+	}
+	for i, l := range logs {
+		next, err := l.NextOffset()
+		require.NoError(t, err)
+		if i == 0 {
+			require.Equal(t, uint64(4), next)
+		} else {
+			require.Equal(t, uint64(1), next)
+		}
+	}
+
+	//every server is ready once a produce went through, but the picker may not have all of them yet
+	require.Eventually(t, func() bool {
+		seen := map[string]int{}
+		for i := 0; i < 4; i++ {
+			res, err := client.Consume(ctx, &log_v1.ConsumeRequest{Offset: 0})
+			if err != nil {
+				return false
+			}
+			seen[string(res.Record.Value)]++
+		}
+		return seen["from 1"] == 2 && seen["from 2"] == 2
+	}, 3*time.Second, 50*time.Millisecond)
+}
+
+func setupServer(t *testing.T, servers server.ServerGetter) (string, *log.Log) {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "loadbalance-test")
+	require.NoError(t, err)
+	clog, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+	srv, err := server.NewGRPCServer(&server.Config{CommitLog: clog, ServerGetter: servers})
+	require.NoError(t, err)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(ln)
+	t.Cleanup(func() {
+		srv.Stop()
+		clog.Remove()
+	})
+	return ln.Addr().String(), clog
+}
+
+type getServers struct {
+	servers []*log_v1.Server
+}
+
+func (s *getServers) GetServers() ([]*log_v1.Server, error) {
+	return s.servers, nil
+}
+
+type clientConn struct {
+	resolver.ClientConn
+	state resolver.State
+}
+
+func (c *clientConn) UpdateState(state resolver.State) error {
+	c.state = state
+	return nil
+}
+
+func (c *clientConn) ReportError(err error) {}
+
+func (c *clientConn) ParseServiceConfig(config string) *serviceconfig.ParseResult {
+	return nil
+}
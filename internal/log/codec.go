@@ -0,0 +1,102 @@
+package log
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"sync"
+)
+
+// Codec is the compression applied to a batch before it is written to the store. The codec of every frame is
+// recorded in the frame itself, so changing Config.Segment.Compression leaves existing segments readable.
+type Codec uint8
+
+const (
+	NoCompression Codec = iota
+	Gzip
+	Snappy
+	Zstd
+)
+
+func (c Codec) String() string {
+	switch c {
+	case NoCompression:
+		return "none"
+	case Gzip:
+		return "gzip"
+	case Snappy:
+		return "snappy"
+	case Zstd:
+		return "zstd"
+	}
+	return fmt.Sprintf("codec(%d)", uint8(c))
+}
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+)
+
+func zstdCodec() (*zstd.Encoder, *zstd.Decoder) {
+	zstdOnce.Do(func() {
+		//EncodeAll and DecodeAll are safe for concurrent use, so one pair serves every store
+		zstdEncoder, _ = zstd.NewWriter(nil)
+		zstdDecoder, _ = zstd.NewReader(nil)
+	})
+	return zstdEncoder, zstdDecoder
+}
+
+// compress returns p compressed with c together with the codec actually used: data that does not shrink is
+// stored uncompressed
+func (c Codec) compress(p []byte) ([]byte, Codec, error) {
+	var out []byte
+	switch c {
+	case NoCompression:
+		return p, NoCompression, nil
+	case Gzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(p); err != nil {
+			return nil, 0, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, 0, err
+		}
+		out = buf.Bytes()
+	case Snappy:
+		out = s2.EncodeSnappy(nil, p)
+	case Zstd:
+		enc, _ := zstdCodec()
+		out = enc.EncodeAll(p, nil)
+	default:
+		return nil, 0, fmt.Errorf("log: unknown compression %s", c)
+	}
+	if len(out) >= len(p) {
+		return p, NoCompression, nil
+	}
+	return out, c, nil
+}
+
+func (c Codec) decompress(p []byte) ([]byte, error) {
+	switch c {
+	case NoCompression:
+		return p, nil
+	case Gzip:
+		r, err := gzip.NewReader(bytes.NewReader(p))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	case Snappy:
+		return s2.Decode(nil, p)
+	case Zstd:
+		_, dec := zstdCodec()
+		return dec.DecodeAll(p, nil)
+	}
+	return nil, fmt.Errorf("log: unknown compression %s", c)
+}
@@ -0,0 +1,136 @@
+package log
+
+import (
+	"bytes"
+	"fmt"
+	log_v1 "github.com/mishamolnar/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+var codecs = []Codec{NoCompression, Gzip, Snappy, Zstd}
+
+// telemetryBatch resembles what our producers send: many small JSON-ish records appended together
+func telemetryBatch(n int) []byte {
+	batch := &log_v1.RecordBatch{}
+	for i := 0; i < n; i++ {
+		batch.Records = append(batch.Records, &log_v1.Record{
+			Value:  []byte(fmt.Sprintf(`{"host":"node-%d","metric":"cpu.load","value":%d,"tags":["prod","eu-west"]}`, i%8, i)),
+			Offset: uint64(i),
+		})
+	}
+	b, _ := proto.Marshal(batch)
+	return b
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	compressible := telemetryBatch(100)
+	random := make([]byte, 512)
+	rand.New(rand.NewSource(1)).Read(random)
+	for _, c := range codecs {
+		t.Run(c.String(), func(t *testing.T) {
+			out, used, err := c.compress(compressible)
+			require.NoError(t, err)
+			require.Equal(t, c, used)
+			if c != NoCompression {
+				require.Less(t, len(out), len(compressible))
+			}
+			in, err := used.decompress(out)
+			require.NoError(t, err)
+			require.Equal(t, compressible, in)
+
+			//data that does not shrink is kept as is
+			out, used, err = c.compress(random)
+			require.NoError(t, err)
+			require.Equal(t, NoCompression, used)
+			require.Equal(t, random, out)
+		})
+	}
+	_, _, err := Codec(42).compress(compressible)
+	require.Error(t, err)
+}
+
+func TestStoreMixedCodecs(t *testing.T) {
+	f, err := os.CreateTemp("", "store_mixed_codecs_test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	payload := telemetryBatch(20)
+	var positions []uint64
+	for _, codec := range codecs {
+		var c Config
+		c.Segment.Compression = codec
+		s, err := newStore(f, c)
+		require.NoError(t, err)
+		_, pos, err := s.Append(payload)
+		require.NoError(t, err)
+		require.NoError(t, s.Sync())
+		positions = append(positions, pos)
+	}
+
+	s, err := newStore(f, Config{})
+	require.NoError(t, err)
+	for i, pos := range positions {
+		frame, err := s.frame(pos)
+		require.NoError(t, err)
+		require.Equal(t, byte(codecs[i]), frame[lenWidth+crcWidth])
+		data, err := s.Read(pos)
+		require.NoError(t, err)
+		require.True(t, bytes.Equal(payload, data))
+	}
+}
+
+func BenchmarkCodecs(b *testing.B) {
+	payload := telemetryBatch(100)
+	for _, c := range codecs {
+		b.Run(c.String()+"/compress", func(b *testing.B) {
+			b.SetBytes(int64(len(payload)))
+			var out []byte
+			for i := 0; i < b.N; i++ {
+				out, _, _ = c.compress(payload)
+			}
+			b.ReportMetric(float64(len(payload))/float64(len(out)), "ratio")
+		})
+		b.Run(c.String()+"/decompress", func(b *testing.B) {
+			out, used, err := c.compress(payload)
+			require.NoError(b, err)
+			b.SetBytes(int64(len(payload)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := used.decompress(out); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkLogAppendCompressed(b *testing.B) {
+	batch := &log_v1.RecordBatch{}
+	require.NoError(b, proto.Unmarshal(telemetryBatch(100), batch))
+	for _, codec := range codecs {
+		b.Run(codec.String(), func(b *testing.B) {
+			dir, err := os.MkdirTemp("", "codec-bench")
+			require.NoError(b, err)
+			defer os.RemoveAll(dir)
+			var c Config
+			c.Segment.MaxStoreBytes = 1 << 30
+			c.Segment.MaxIndexBytes = 1 << 20
+			c.Segment.Compression = codec
+			l, err := NewLog(dir, c)
+			require.NoError(b, err)
+			defer l.Close()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := l.AppendBatch(batch.Records); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(l.activeSegment.store.size)/float64(b.N), "disk-bytes/batch")
+		})
+	}
+}
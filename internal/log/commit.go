@@ -0,0 +1,104 @@
+package log
+
+import log_v1 "github.com/mishamolnar/proglog/api/v1"
+
+// pendingAppend is a batch of records waiting for the group commit it belongs to
+type pendingAppend struct {
+	records []*log_v1.Record
+	offset  uint64
+	err     error
+	leader  bool
+	done    chan struct{}
+}
+
+// groupCommit queues the batch and either waits for the current leader to commit it or becomes the leader
+// itself. The leader takes every queued batch, writes them under one lock, syncs once for the whole group and
+// then hands leadership to the first caller that queued up in the meantime.
+func (l *Log) groupCommit(records []*log_v1.Record) (uint64, error) {
+	p := &pendingAppend{records: records, done: make(chan struct{})}
+	l.commitMu.Lock()
+	l.pending = append(l.pending, p)
+	if l.committing {
+		l.commitMu.Unlock()
+		<-p.done
+		if !p.leader {
+			return p.offset, p.err
+		}
+		l.commitMu.Lock()
+	}
+	l.committing = true
+	group := l.pending
+	l.pending = nil
+	l.commitMu.Unlock()
+
+	l.commit(group)
+	for _, q := range group {
+		if q != p {
+			close(q.done)
+		}
+	}
+
+	l.commitMu.Lock()
+	if len(l.pending) > 0 {
+		next := l.pending[0]
+		next.leader = true
+		close(next.done)
+	} else {
+		l.committing = false
+	}
+	l.commitMu.Unlock()
+	return p.offset, p.err
+}
+
+// commit appends the group to the log and syncs it according to Config.Durability
+func (l *Log) commit(group []*pendingAppend) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		for _, p := range group {
+			p.err = ErrClosed
+		}
+		return
+	}
+	for _, p := range group {
+		p.offset, p.err = l.append(p.records)
+	}
+	close(l.appended)
+	l.appended = make(chan struct{})
+	d := l.Config.Durability
+	if !d.SyncEveryAppend && (d.SyncEveryNBytes == 0 || l.unsynced < d.SyncEveryNBytes) {
+		return
+	}
+	if err := l.sync(); err != nil {
+		for _, p := range group {
+			if p.err == nil {
+				p.err = err
+			}
+		}
+	}
+}
+
+func (l *Log) append(records []*log_v1.Record) (uint64, error) {
+	now := l.now().UnixNano()
+	for _, record := range records {
+		if record.Timestamp == 0 {
+			record.Timestamp = now
+		}
+	}
+	size := l.activeSegment.store.size
+	offset, err := l.activeSegment.Append(records...)
+	if err != nil {
+		return 0, err
+	}
+	l.unsynced += l.activeSegment.store.size - size
+	if l.activeSegment.IsMaxed() {
+		if err = l.activeSegment.Sync(); err != nil {
+			return 0, err
+		}
+		l.unsynced = 0
+		if err = l.newSegment(l.activeSegment.nextOffset); err != nil {
+			return 0, err
+		}
+	}
+	return offset, nil
+}
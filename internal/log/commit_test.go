@@ -0,0 +1,81 @@
+package log
+
+import (
+	log_v1 "github.com/mishamolnar/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestGroupCommit(t *testing.T) {
+	dir, err := os.MkdirTemp("", "group-commit-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	var c Config
+	c.Segment.MaxStoreBytes = 256
+	c.Durability.SyncEveryAppend = true
+	l, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer l.Close()
+
+	//block the first leader inside commit so that everyone else queues up behind it
+	l.mu.Lock()
+	const appenders = 50
+	type appended struct {
+		off uint64
+		err error
+	}
+	results := make(chan appended, appenders)
+	for i := 0; i < appenders; i++ {
+		go func() {
+			off, err := l.Append(&log_v1.Record{Value: []byte("grouped")})
+			results <- appended{off, err}
+		}()
+	}
+	require.Eventually(t, func() bool {
+		l.commitMu.Lock()
+		defer l.commitMu.Unlock()
+		return len(l.pending) == appenders-1
+	}, time.Second, time.Millisecond)
+	l.mu.Unlock()
+
+	seen := make(map[uint64]bool)
+	for i := 0; i < appenders; i++ {
+		res := <-results
+		require.NoError(t, res.err)
+		off := res.off
+		require.False(t, seen[off])
+		seen[off] = true
+		read, err := l.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, off, read.Offset)
+	}
+	require.Len(t, seen, appenders)
+	l.commitMu.Lock()
+	require.False(t, l.committing)
+	l.commitMu.Unlock()
+	require.Greater(t, len(l.segments), 1)
+}
+
+func BenchmarkAppendParallel(b *testing.B) {
+	dir, err := os.MkdirTemp("", "group-commit-bench")
+	require.NoError(b, err)
+	defer os.RemoveAll(dir)
+	var c Config
+	c.Segment.MaxStoreBytes = 64 << 20
+	c.Segment.MaxIndexBytes = 64 << 20
+	c.Durability.SyncEveryAppend = true
+	l, err := NewLog(dir, c)
+	require.NoError(b, err)
+	defer l.Close()
+	b.SetParallelism(64)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := l.Append(&log_v1.Record{Value: []byte("benchmark")}); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+}
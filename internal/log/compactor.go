@@ -0,0 +1,210 @@
+package log
+
+import (
+	"errors"
+	log_v1 "github.com/mishamolnar/proglog/api/v1"
+	"google.golang.org/protobuf/proto"
+	"os"
+	"path/filepath"
+)
+
+const compactionDir = ".compaction"
+
+// CompactionStats describes what a compaction pass changed
+type CompactionStats struct {
+	Segments       int
+	RemovedRecords uint64
+	RemovedBytes   uint64
+}
+
+// isTombstone tells whether the record deletes its key: a keyed record marked as a tombstone
+func isTombstone(r *log_v1.Record) bool {
+	return r.Tombstone && len(r.Key) > 0
+}
+
+// Compact rewrites the closed segments so that they keep only the newest record of every key, dropping
+// tombstones older than Config.Compaction.TombstoneRetention. Records without a key are kept. Records keep
+// their offsets, reading an offset that was compacted away returns the next record left in the log.
+// The active segment is only read, new appends are never held back by the rewrite, and every compacted
+// segment is swapped in under the log lock so readers see either the old or the new one.
+func (l *Log) Compact() (CompactionStats, error) {
+	l.compactMu.Lock()
+	defer l.compactMu.Unlock()
+	var stats CompactionStats
+	tmp := filepath.Join(l.Dir, compactionDir)
+	if err := os.RemoveAll(tmp); err != nil {
+		return stats, err
+	}
+	if err := os.Mkdir(tmp, 0755); err != nil {
+		return stats, err
+	}
+	defer os.RemoveAll(tmp)
+
+	l.mu.RLock()
+	segments := append([]*segment(nil), l.segments...)
+	active := make(map[string]uint64)
+	err := l.activeSegment.batches(func(b *log_v1.RecordBatch) {
+		indexLatest(active, b)
+	})
+	now := l.now()
+	l.mu.RUnlock()
+	if err != nil {
+		return stats, err
+	}
+	//closed segments never change, but retention may remove them while we read: the pass then fails
+	closed := segments[:len(segments)-1]
+	latest := make(map[string]uint64)
+	for _, s := range closed {
+		if err = s.batches(func(b *log_v1.RecordBatch) { indexLatest(latest, b) }); err != nil {
+			return stats, err
+		}
+	}
+	for key, off := range active {
+		latest[key] = off
+	}
+
+	horizon := now.Add(-l.Config.Compaction.TombstoneRetention).UnixNano()
+	for _, s := range closed {
+		compacted, removed, err := l.compactSegment(s, tmp, latest, horizon)
+		if err != nil {
+			return stats, err
+		}
+		if removed == 0 {
+			if err = compacted.Remove(); err != nil {
+				return stats, err
+			}
+			continue
+		}
+		before := s.store.size
+		swapped, err := l.swap(s, compacted)
+		if err != nil {
+			return stats, err
+		}
+		if swapped {
+			stats.Segments++
+			stats.RemovedRecords += removed
+			stats.RemovedBytes += before - compacted.store.size
+		}
+	}
+	return stats, nil
+}
+
+func indexLatest(latest map[string]uint64, b *log_v1.RecordBatch) {
+	for _, r := range b.Records {
+		if len(r.Key) > 0 {
+			latest[string(r.Key)] = r.Offset
+		}
+	}
+}
+
+// compactSegment writes the records of s worth keeping to a segment of the same base offset in dir
+func (l *Log) compactSegment(s *segment, dir string, latest map[string]uint64, horizon int64) (*segment, uint64, error) {
+	compacted, err := newSegment(dir, s.baseOffset, l.Config)
+	if err != nil {
+		return nil, 0, err
+	}
+	var removed uint64
+	var werr error
+	err = s.batches(func(b *log_v1.RecordBatch) {
+		kept := &log_v1.RecordBatch{}
+		for _, r := range b.Records {
+			newest := len(r.Key) == 0 || latest[string(r.Key)] == r.Offset
+			if !newest || (isTombstone(r) && r.Timestamp < horizon) {
+				removed++
+				continue
+			}
+			kept.Records = append(kept.Records, r)
+		}
+		if len(kept.Records) == 0 || werr != nil {
+			return
+		}
+		kept.BaseOffset = kept.Records[0].Offset
+		werr = compacted.appendBatch(kept)
+	})
+	if err == nil {
+		err = werr
+	}
+	if err == nil {
+		err = compacted.Sync()
+	}
+	if err != nil {
+		_ = compacted.Remove()
+		return nil, 0, err
+	}
+	return compacted, removed, nil
+}
+
+// swap replaces old with its compacted copy. The files are renamed with the store last, so a crash half way
+// leaves either the old store or the new one, and recovery rebuilds indexes that do not match it.
+func (l *Log) swap(old, compacted *segment) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	i := 0
+	for i < len(l.segments) && l.segments[i] != old {
+		i++
+	}
+	if i == len(l.segments) { //removed by retention in the meantime
+		return false, compacted.Remove()
+	}
+	//nothing left at all: the first segment stays, empty, to keep the lowest offset of the log where it was
+	if compacted.nextOffset == compacted.baseOffset && i > 0 {
+		if err := compacted.Remove(); err != nil {
+			return false, err
+		}
+		if err := old.Remove(); err != nil {
+			return false, err
+		}
+		l.segments = append(l.segments[:i], l.segments[i+1:]...)
+		return true, nil
+	}
+	if err := compacted.Close(); err != nil {
+		return false, err
+	}
+	if err := old.Close(); err != nil {
+		return false, err
+	}
+	for _, f := range []struct{ from, to string }{
+		{compacted.index.Name(), old.index.Name()},
+		{compacted.timeIndex.Name(), old.timeIndex.Name()},
+		{compacted.store.Name(), old.store.Name()},
+	} {
+		if err := os.Rename(f.from, f.to); err != nil {
+			return false, err
+		}
+	}
+	s, err := newSegment(l.Dir, old.baseOffset, l.Config)
+	if err != nil {
+		return false, err
+	}
+	l.segments[i] = s
+	return true, nil
+}
+
+// batches calls fn with every batch of the segment in order, reading the store frame by frame
+func (s *segment) batches(fn func(*log_v1.RecordBatch)) error {
+	size := s.store.size
+	next := s.baseOffset
+	for pos := uint64(0); pos < size; {
+		n, err := s.store.frameSize(pos)
+		if err != nil {
+			return err
+		}
+		data, err := s.store.Read(pos)
+		if errors.Is(err, errChecksum) {
+			return log_v1.ErrCorruptRecord{Offset: next, Segment: s.baseOffset}
+		}
+		if err != nil {
+			return err
+		}
+		batch := &log_v1.RecordBatch{}
+		if err = proto.Unmarshal(data, batch); err != nil {
+			return err
+		}
+		fn(batch)
+		if len(batch.Records) > 0 {
+			next = batch.Records[len(batch.Records)-1].Offset + 1
+		}
+		pos += n
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package log
+
+import (
+	"fmt"
+	log_v1 "github.com/mishamolnar/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCompaction(t *testing.T) {
+	for scenario, fn := range map[string]func(t *testing.T, l *Log){
+		"keeps only the newest record per key": testCompactLatest,
+		"tombstones outlive their retention":   testCompactTombstones,
+		"fully compacted segments go away":     testCompactEmptySegment,
+		"readers never see a half swap":        testCompactConcurrentReads,
+		"concurrent compactions take turns":    testCompactConcurrent,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "compaction-test")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+			var c Config
+			c.Segment.MaxStoreBytes = 200
+			c.Compaction.TombstoneRetention = time.Hour
+			l, err := NewLog(dir, c)
+			require.NoError(t, err)
+			defer l.Close()
+			fn(t, l)
+		})
+	}
+}
+
+func appendKeyed(t *testing.T, l *Log, key, value string) uint64 {
+	t.Helper()
+	r := &log_v1.Record{Value: []byte(value)}
+	if key != "" {
+		r.Key = []byte(key)
+	}
+	off, err := l.Append(r)
+	require.NoError(t, err)
+	return off
+}
+
+func testCompactLatest(t *testing.T, l *Log) {
+	want := make(map[string]uint64)
+	var keyless []uint64
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("key-%d", i%3)
+		want[key] = appendKeyed(t, l, key, fmt.Sprintf("value-%d", i))
+		if i%10 == 0 {
+			keyless = append(keyless, appendKeyed(t, l, "", "no key"))
+		}
+	}
+	require.Greater(t, len(l.segments), 3)
+	stats, err := l.Compact()
+	require.NoError(t, err)
+	require.NotZero(t, stats.Segments)
+	require.NotZero(t, stats.RemovedBytes)
+
+	survivors := make(map[uint64]bool)
+	for _, off := range keyless {
+		survivors[off] = true
+	}
+	for _, off := range want {
+		survivors[off] = true
+	}
+	//the newest records of the active segment were never candidates, older ones of the same keys went
+	active := l.activeSegment.baseOffset
+	hi, err := l.HighestOffset()
+	require.NoError(t, err)
+	removed := uint64(0)
+	for off := uint64(0); off <= hi; off++ {
+		read, err := l.Read(off)
+		require.NoError(t, err)
+		switch {
+		case survivors[off] || off >= active:
+			require.Equal(t, off, read.Offset)
+		case read.Offset != off:
+			require.Greater(t, read.Offset, off)
+			removed++
+		}
+	}
+	require.Equal(t, stats.RemovedRecords, removed)
+
+	//a reopened log reads the same without repairs
+	require.NoError(t, l.Close())
+	reopened, err := NewLog(l.Dir, l.Config)
+	require.NoError(t, err)
+	defer reopened.Close()
+	require.Empty(t, reopened.Recovered())
+	for key, off := range want {
+		read, err := reopened.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, key, string(read.Key))
+	}
+}
+
+func testCompactTombstones(t *testing.T, l *Log) {
+	now := time.Unix(1700000000, 0)
+	l.now = func() time.Time { return now }
+	first := appendKeyed(t, l, "gone", "value")
+	empty := appendKeyed(t, l, "empty", "")
+	tombstone, err := l.Append(&log_v1.Record{Key: []byte("gone"), Tombstone: true})
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		appendKeyed(t, l, "", "filler to roll the segment")
+	}
+
+	_, err = l.Compact()
+	require.NoError(t, err)
+	read, err := l.Read(first)
+	require.NoError(t, err)
+	require.Equal(t, empty, read.Offset)
+	read, err = l.Read(tombstone)
+	require.NoError(t, err)
+	require.Equal(t, tombstone, read.Offset)
+	require.True(t, isTombstone(read))
+
+	now = now.Add(2 * time.Hour)
+	stats, err := l.Compact()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), stats.RemovedRecords)
+	read, err = l.Read(tombstone)
+	require.NoError(t, err)
+	require.Greater(t, read.Offset, tombstone)
+	require.False(t, isTombstone(read))
+	//an empty value is a value like any other
+	read, err = l.Read(empty)
+	require.NoError(t, err)
+	require.Equal(t, empty, read.Offset)
+}
+
+func testCompactEmptySegment(t *testing.T, l *Log) {
+	for i := 0; i < 20; i++ {
+		appendKeyed(t, l, "same", fmt.Sprintf("version %d", i))
+	}
+	before := len(l.segments)
+	_, err := l.Compact()
+	require.NoError(t, err)
+	require.Less(t, len(l.segments), before)
+	require.Equal(t, uint64(0), l.segments[0].baseOffset)
+	require.Zero(t, l.segments[0].store.size)
+
+	read, err := l.Read(0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(19), read.Offset)
+	_, err = l.Read(20)
+	require.Equal(t, log_v1.ErrOffsetOutOfRange{Offset: 20}, err)
+}
+
+func testCompactConcurrentReads(t *testing.T, l *Log) {
+	for i := 0; i < 40; i++ {
+		appendKeyed(t, l, fmt.Sprintf("key-%d", i%4), fmt.Sprintf("value-%d", i))
+	}
+	done := make(chan struct{})
+	errs := make(chan error, 4)
+	for r := 0; r < cap(errs); r++ {
+		go func() {
+			errs <- func() error {
+				for off := uint64(0); ; off = (off + 1) % 40 {
+					select {
+					case <-done:
+						return nil
+					default:
+					}
+					read, err := l.Read(off)
+					if err != nil {
+						return err
+					}
+					if read.Offset < off || string(read.Key) != fmt.Sprintf("key-%d", read.Offset%4) {
+						return fmt.Errorf("read %d at offset %d with key %q", read.Offset, off, read.Key)
+					}
+				}
+			}()
+		}()
+	}
+	for i := 0; i < 3; i++ {
+		_, err := l.Compact()
+		require.NoError(t, err)
+		appendKeyed(t, l, "key-0", "more")
+	}
+	close(done)
+	for r := 0; r < cap(errs); r++ {
+		require.NoError(t, <-errs)
+	}
+}
+
+func testCompactConcurrent(t *testing.T, l *Log) {
+	for i := 0; i < 40; i++ {
+		appendKeyed(t, l, fmt.Sprintf("key-%d", i%4), fmt.Sprintf("value-%d", i))
+	}
+	errs := make(chan error, 4)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			_, err := l.Compact()
+			errs <- err
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		require.NoError(t, <-errs)
+	}
+	for i := 0; i < 4; i++ {
+		read, err := l.Read(uint64(36 + i))
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("value-%d", 36+i), string(read.Value))
+	}
+	_, err := os.Stat(filepath.Join(l.Dir, compactionDir))
+	require.True(t, os.IsNotExist(err))
+}
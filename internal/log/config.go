@@ -1,9 +1,35 @@
 package log
 
+import "time"
+
 type Config struct {
 	Segment struct {
 		MaxStoreBytes uint64
 		MaxIndexBytes uint64
 		InitialOffset uint64
+		Compression   Codec
+		// TimeIndexIntervalBytes is roughly how much of the store lies between two entries of the time index
+		TimeIndexIntervalBytes uint64
+	}
+	// Durability decides when appended records are fsynced. The active segment is always synced when it rolls
+	// and when the log is closed, with everything zero nothing else is synced.
+	Durability struct {
+		SyncEveryAppend bool
+		SyncInterval    time.Duration
+		SyncEveryNBytes uint64
+	}
+	// Retention deletes the oldest closed segments once their newest record is older than MaxAge or the log is
+	// larger than MaxBytes. Zero disables a limit; the active segment is never deleted.
+	Retention struct {
+		MaxAge        time.Duration
+		MaxBytes      uint64
+		CheckInterval time.Duration
+		// OnDelete, when set, is called by the background cleaner for every segment it deleted
+		OnDelete func(SegmentInfo)
+	}
+	// Compaction configures Log.Compact
+	Compaction struct {
+		// TombstoneRetention is how long a tombstone is kept after it became the newest record of its key
+		TombstoneRetention time.Duration
 	}
 }
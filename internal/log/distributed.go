@@ -0,0 +1,501 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+	log_v1 "github.com/mishamolnar/proglog/api/v1"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DistributedLog replicates a Log over Raft: appends go through consensus and are applied on every node, reads
+// are served by the local copy. The local copy is rebuilt from Raft's snapshot and log every time the node starts
+type DistributedLog struct {
+	config  DistributedConfig
+	dataDir string
+	log     *Log
+	raft    *raft.Raft
+	// stores are closed after raft shuts down
+	logStore    *logStore
+	stableStore *raftboltdb.BoltStore
+}
+
+// DistributedConfig configures a DistributedLog
+type DistributedConfig struct {
+	// Log configures the local copy of the log, Raft's own log takes its segment settings
+	Log Config
+	// Raft settings that are set override Raft's defaults, LocalID is required
+	Raft        raft.Config
+	StreamLayer *StreamLayer
+	// Bootstrap starts a new cluster with this node as its only voter
+	Bootstrap bool
+}
+
+func NewDistributedLog(dataDir string, config DistributedConfig) (*DistributedLog, error) {
+	if config.StreamLayer == nil {
+		return nil, errors.New("distributed log: no stream layer")
+	}
+	if config.Raft.LocalID == "" {
+		return nil, errors.New("distributed log: no local ID")
+	}
+	l := &DistributedLog{config: config, dataDir: dataDir}
+	if err := l.setupLog(dataDir); err != nil {
+		return nil, err
+	}
+	if err := l.setupRaft(dataDir); err != nil {
+		l.log.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *DistributedLog) setupLog(dataDir string) error {
+	logDir := filepath.Join(dataDir, "log")
+	if err := os.RemoveAll(logDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return err
+	}
+	var err error
+	l.log, err = NewLog(logDir, l.config.Log)
+	return err
+}
+
+func (l *DistributedLog) setupRaft(dataDir string) error {
+	raftDir := filepath.Join(dataDir, "raft")
+	logDir := filepath.Join(raftDir, "log")
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return err
+	}
+	var logConfig Config
+	logConfig.Segment = l.config.Log.Segment
+	logConfig.Segment.InitialOffset = 1 //raft indexes start at one
+	logConfig.Durability.SyncEveryAppend = true
+	logStore, err := newLogStore(logDir, logConfig)
+	if err != nil {
+		return err
+	}
+	stableStore, err := raftboltdb.NewBoltStore(filepath.Join(raftDir, "stable"))
+	if err != nil {
+		logStore.Close()
+		return err
+	}
+	snapshotStore, err := raft.NewFileSnapshotStore(raftDir, 1, os.Stderr)
+	if err != nil {
+		logStore.Close()
+		stableStore.Close()
+		return err
+	}
+	transport := raft.NewNetworkTransport(l.config.StreamLayer, 5, 10*time.Second, os.Stderr)
+
+	config := raft.DefaultConfig()
+	config.LocalID = l.config.Raft.LocalID
+	if l.config.Raft.HeartbeatTimeout != 0 {
+		config.HeartbeatTimeout = l.config.Raft.HeartbeatTimeout
+	}
+	if l.config.Raft.ElectionTimeout != 0 {
+		config.ElectionTimeout = l.config.Raft.ElectionTimeout
+	}
+	if l.config.Raft.LeaderLeaseTimeout != 0 {
+		config.LeaderLeaseTimeout = l.config.Raft.LeaderLeaseTimeout
+	}
+	if l.config.Raft.CommitTimeout != 0 {
+		config.CommitTimeout = l.config.Raft.CommitTimeout
+	}
+	if l.config.Raft.SnapshotThreshold != 0 {
+		config.SnapshotThreshold = l.config.Raft.SnapshotThreshold
+	}
+	if l.config.Raft.TrailingLogs != 0 {
+		config.TrailingLogs = l.config.Raft.TrailingLogs
+	}
+	if l.config.Raft.Logger != nil {
+		config.Logger = l.config.Raft.Logger
+	}
+
+	hasState, err := raft.HasExistingState(logStore, stableStore, snapshotStore)
+	if err != nil {
+		logStore.Close()
+		stableStore.Close()
+		return err
+	}
+	l.raft, err = raft.NewRaft(config, &fsm{log: l.log}, logStore, stableStore, snapshotStore, transport)
+	if err != nil {
+		logStore.Close()
+		stableStore.Close()
+		return err
+	}
+	l.logStore, l.stableStore = logStore, stableStore
+	if l.config.Bootstrap && !hasState {
+		err = l.raft.BootstrapCluster(raft.Configuration{
+			Servers: []raft.Server{{ID: config.LocalID, Address: transport.LocalAddr()}},
+		}).Error()
+		if err != nil {
+			l.raft.Shutdown().Error()
+			logStore.Close()
+			stableStore.Close()
+		}
+	}
+	return err
+}
+
+// Append replicates the record and returns its offset once a quorum has it, only the leader accepts appends
+func (l *DistributedLog) Append(record *log_v1.Record) (uint64, error) {
+	if record.Timestamp == 0 { //stamped once here so that every node stores the same record
+		record.Timestamp = time.Now().UnixNano()
+	}
+	res, err := l.apply(AppendRequestType, &log_v1.ProduceRequest{Record: record})
+	if err != nil {
+		return 0, err
+	}
+	return res.(*log_v1.ProduceResponse).Offset, nil
+}
+
+func (l *DistributedLog) apply(reqType RequestType, req proto.Message) (interface{}, error) {
+	b, err := proto.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	future := l.raft.Apply(append([]byte{byte(reqType)}, b...), 10*time.Second)
+	if err = future.Error(); err != nil {
+		return nil, err
+	}
+	res := future.Response()
+	if err, ok := res.(error); ok {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Read reads from the local copy, a follower may not have the latest records yet
+func (l *DistributedLog) Read(off uint64) (*log_v1.Record, error) {
+	return l.log.Read(off)
+}
+
+func (l *DistributedLog) ReadRange(off uint64, maxRecords int, maxBytes uint64) ([]*log_v1.Record, error) {
+	return l.log.ReadRange(off, maxRecords, maxBytes)
+}
+
+func (l *DistributedLog) OffsetForTime(t time.Time) (uint64, error) {
+	return l.log.OffsetForTime(t)
+}
+
+func (l *DistributedLog) LowestOffset() (uint64, error) {
+	return l.log.LowestOffset()
+}
+
+func (l *DistributedLog) NextOffset() (uint64, error) {
+	return l.log.NextOffset()
+}
+
+func (l *DistributedLog) Wait(ctx context.Context, off uint64) error {
+	return l.log.Wait(ctx, off)
+}
+
+// Join adds the server as a voter, replacing a server that had its ID or address before
+func (l *DistributedLog) Join(id, addr string) error {
+	configFuture := l.raft.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return err
+	}
+	serverID, serverAddr := raft.ServerID(id), raft.ServerAddress(addr)
+	for _, srv := range configFuture.Configuration().Servers {
+		if srv.ID == serverID && srv.Address == serverAddr {
+			return nil
+		}
+		if srv.ID == serverID || srv.Address == serverAddr {
+			if err := l.raft.RemoveServer(srv.ID, 0, 0).Error(); err != nil {
+				return err
+			}
+		}
+	}
+	return l.raft.AddVoter(serverID, serverAddr, 0, 0).Error()
+}
+
+func (l *DistributedLog) Leave(id string) error {
+	return l.raft.RemoveServer(raft.ServerID(id), 0, 0).Error()
+}
+
+// GetServers lists the servers of the cluster, the leader being the only writable one. Their RPC addresses are
+// their Raft addresses, so the RPC server has to share the Raft listener through a Mux
+func (l *DistributedLog) GetServers() ([]*log_v1.Server, error) {
+	future := l.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return nil, err
+	}
+	leaderAddr, _ := l.raft.LeaderWithID()
+	var servers []*log_v1.Server
+	for _, srv := range future.Configuration().Servers {
+		servers = append(servers, &log_v1.Server{
+			Id:       string(srv.ID),
+			RpcAddr:  string(srv.Address),
+			Writable: srv.Address == leaderAddr,
+		})
+	}
+	return servers, nil
+}
+
+// WaitForLeader blocks until the cluster has elected a leader or the timeout passes
+func (l *DistributedLog) WaitForLeader(timeout time.Duration) error {
+	timeoutc := time.After(timeout)
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-timeoutc:
+			return errors.New("timed out waiting for a leader")
+		case <-ticker.C:
+			if addr, _ := l.raft.LeaderWithID(); addr != "" {
+				return nil
+			}
+		}
+	}
+}
+
+func (l *DistributedLog) Close() error {
+	if err := l.raft.Shutdown().Error(); err != nil {
+		return err
+	}
+	if err := l.logStore.Close(); err != nil {
+		return err
+	}
+	if err := l.stableStore.Close(); err != nil {
+		return err
+	}
+	return l.log.Close()
+}
+
+type RequestType uint8
+
+const (
+	AppendRequestType RequestType = 0
+)
+
+var _ raft.FSM = (*fsm)(nil)
+
+// fsm applies the committed Raft entries to the local Log
+type fsm struct {
+	log *Log
+}
+
+func (f *fsm) Apply(record *raft.Log) interface{} {
+	if len(record.Data) == 0 {
+		return fmt.Errorf("empty raft command at %d", record.Index)
+	}
+	switch RequestType(record.Data[0]) {
+	case AppendRequestType:
+		return f.applyAppend(record.Data[1:])
+	}
+	return fmt.Errorf("unknown raft command %d at %d", record.Data[0], record.Index)
+}
+
+func (f *fsm) applyAppend(b []byte) interface{} {
+	var req log_v1.ProduceRequest
+	if err := proto.Unmarshal(b, &req); err != nil {
+		return err
+	}
+	offset, err := f.log.Append(req.Record)
+	if err != nil {
+		return err
+	}
+	return &log_v1.ProduceResponse{Offset: offset}
+}
+
+// Snapshot captures where the segments end, Persist copies them up to there without holding up Apply
+func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
+	f.log.mu.RLock()
+	defer f.log.mu.RUnlock()
+	return &fsmSnapshot{segments: f.log.snapshotSegments()}, nil
+}
+
+func (f *fsm) Restore(r io.ReadCloser) error {
+	defer r.Close()
+	return f.log.restore(r)
+}
+
+var _ raft.FSMSnapshot = (*fsmSnapshot)(nil)
+
+type fsmSnapshot struct {
+	segments []snapshotSegment
+}
+
+func (s *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
+	if err := writeSnapshot(sink, s.segments); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s *fsmSnapshot) Release() {
+	releaseSnapshot(s.segments)
+}
+
+var _ raft.LogStore = (*logStore)(nil)
+
+// logStore keeps Raft's log in a Log, the offset of every record being its Raft index. Terms, types and
+// extensions travel in record headers
+type logStore struct {
+	*Log
+}
+
+const (
+	raftTermHeader       = "raft-term"
+	raftTypeHeader       = "raft-type"
+	raftExtensionsHeader = "raft-extensions"
+)
+
+func newLogStore(dir string, c Config) (*logStore, error) {
+	l, err := NewLog(dir, c)
+	if err != nil {
+		return nil, err
+	}
+	return &logStore{l}, nil
+}
+
+func (l *logStore) FirstIndex() (uint64, error) {
+	return l.LowestOffset()
+}
+
+func (l *logStore) LastIndex() (uint64, error) {
+	next, err := l.NextOffset()
+	return next - 1, err
+}
+
+func (l *logStore) GetLog(index uint64, out *raft.Log) error {
+	rec, err := l.Read(index)
+	if _, ok := err.(log_v1.ErrOffsetOutOfRange); ok || err == nil && rec.Offset != index {
+		return raft.ErrLogNotFound
+	}
+	if err != nil {
+		return err
+	}
+	out.Index = rec.Offset
+	out.Data = rec.Value
+	out.AppendedAt = time.Unix(0, rec.Timestamp)
+	for _, h := range rec.Headers {
+		switch h.Key {
+		case raftTermHeader:
+			out.Term = enc.Uint64(h.Value)
+		case raftTypeHeader:
+			out.Type = raft.LogType(h.Value[0])
+		case raftExtensionsHeader:
+			out.Extensions = h.Value
+		}
+	}
+	return nil
+}
+
+func (l *logStore) StoreLog(record *raft.Log) error {
+	return l.StoreLogs([]*raft.Log{record})
+}
+
+func (l *logStore) StoreLogs(records []*raft.Log) error {
+	batch := make([]*log_v1.Record, len(records))
+	for i, r := range records {
+		headers := []*log_v1.Header{
+			{Key: raftTermHeader, Value: enc.AppendUint64(nil, r.Term)},
+			{Key: raftTypeHeader, Value: []byte{byte(r.Type)}},
+		}
+		if len(r.Extensions) > 0 {
+			headers = append(headers, &log_v1.Header{Key: raftExtensionsHeader, Value: r.Extensions})
+		}
+		batch[i] = &log_v1.Record{Value: r.Data, Headers: headers, Timestamp: r.AppendedAt.UnixNano()}
+		if batch[i].Timestamp <= 0 {
+			batch[i].Timestamp = 1 //left for the log to stamp otherwise
+		}
+	}
+	next, err := l.NextOffset()
+	if err != nil {
+		return err
+	}
+	switch first := records[0].Index; {
+	case first > next: //a snapshot was installed past the end of the log
+		if err = l.resetTo(first); err != nil {
+			return err
+		}
+	case first < next:
+		return fmt.Errorf("raft log index %d does not follow %d", first, next-1)
+	}
+	_, err = l.AppendBatch(batch)
+	return err
+}
+
+// DeleteRange removes a prefix after a snapshot, a conflicting suffix on a follower or, after a follower
+// installed a snapshot, everything
+func (l *logStore) DeleteRange(min, max uint64) error {
+	first, err := l.FirstIndex()
+	if err != nil {
+		return err
+	}
+	last, err := l.LastIndex()
+	if err != nil {
+		return err
+	}
+	switch {
+	case min <= first && max >= last:
+		return l.resetTo(max + 1)
+	case min <= first:
+		return l.Truncate(max + 1)
+	}
+	return l.truncateFrom(min)
+}
+
+const RaftRPC = 1
+
+var _ raft.StreamLayer = (*StreamLayer)(nil)
+
+// StreamLayer carries Raft's connections over a listener, each starting with a RaftRPC byte so they can share
+// a port with other protocols
+type StreamLayer struct {
+	ln net.Listener
+}
+
+func NewStreamLayer(ln net.Listener) *StreamLayer {
+	return &StreamLayer{ln: ln}
+}
+
+func (s *StreamLayer) Dial(addr raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	conn, err := (&net.Dialer{Timeout: timeout}).Dial("tcp", string(addr))
+	if err != nil {
+		return nil, err
+	}
+	if _, err = conn.Write([]byte{byte(RaftRPC)}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (s *StreamLayer) Accept() (net.Conn, error) {
+	conn, err := s.ln.Accept()
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, 1)
+	if _, err = io.ReadFull(conn, b); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !bytes.Equal(b, []byte{byte(RaftRPC)}) {
+		conn.Close()
+		return nil, errors.New("not a raft connection")
+	}
+	return conn, nil
+}
+
+func (s *StreamLayer) Close() error {
+	return s.ln.Close()
+}
+
+func (s *StreamLayer) Addr() net.Addr {
+	return s.ln.Addr()
+}
@@ -0,0 +1,216 @@
+package log
+
+import (
+	"fmt"
+	"github.com/hashicorp/raft"
+	log_v1 "github.com/mishamolnar/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDistributedLog(t *testing.T) {
+	for scenario, fn := range map[string]func(t *testing.T, logs []*DistributedLog){
+		"appends replicate to every node":    testDistributedReplicate,
+		"a node that left stops replicating": testDistributedLeave,
+		"a new leader takes over appends":    testDistributedFailover,
+		"a restarted node catches up":        testDistributedRestart,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			logs := setupDistributed(t, 3)
+			defer func() {
+				for _, l := range logs {
+					l.Close()
+				}
+			}()
+			fn(t, logs)
+		})
+	}
+}
+
+func setupDistributed(t *testing.T, n int) []*DistributedLog {
+	t.Helper()
+	var logs []*DistributedLog
+	for i := 0; i < n; i++ {
+		dir, err := os.MkdirTemp("", "distributed-log-test")
+		require.NoError(t, err)
+		t.Cleanup(func() { os.RemoveAll(dir) })
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		var c DistributedConfig
+		c.Log.Segment.MaxStoreBytes = 1024
+		c.StreamLayer = NewStreamLayer(ln)
+		c.Raft.LocalID = raft.ServerID(fmt.Sprintf("%d", i))
+		c.Raft.HeartbeatTimeout = 50 * time.Millisecond
+		c.Raft.ElectionTimeout = 50 * time.Millisecond
+		c.Raft.LeaderLeaseTimeout = 50 * time.Millisecond
+		c.Raft.CommitTimeout = 5 * time.Millisecond
+		c.Bootstrap = i == 0
+		c.Raft.SnapshotThreshold = 10
+		c.Raft.TrailingLogs = 5
+		l, err := NewDistributedLog(dir, c)
+		require.NoError(t, err)
+		if i == 0 {
+			require.NoError(t, l.WaitForLeader(3*time.Second))
+		} else {
+			require.NoError(t, logs[0].Join(fmt.Sprintf("%d", i), ln.Addr().String()))
+		}
+		logs = append(logs, l)
+	}
+	return logs
+}
+
+func requireReplicated(t *testing.T, logs []*DistributedLog, records []*log_v1.Record, offsets []uint64) {
+	t.Helper()
+	for _, l := range logs {
+		require.Eventually(t, func() bool {
+			for i, off := range offsets {
+				got, err := l.Read(off)
+				if err != nil || string(got.Value) != string(records[i].Value) || got.Timestamp != records[i].Timestamp {
+					return false
+				}
+			}
+			return true
+		}, 3*time.Second, 20*time.Millisecond)
+	}
+}
+
+func testDistributedReplicate(t *testing.T, logs []*DistributedLog) {
+	var records []*log_v1.Record
+	var offsets []uint64
+	for i := 0; i < 50; i++ {
+		record := &log_v1.Record{Value: []byte(fmt.Sprintf("record %d", i))}
+		off, err := logs[0].Append(record)
+		require.NoError(t, err)
+		require.Equal(t, uint64(i), off)
+		records, offsets = append(records, record), append(offsets, off)
+	}
+	requireReplicated(t, logs, records, offsets)
+
+	_, err := logs[1].Append(&log_v1.Record{Value: []byte("follower")})
+	require.ErrorIs(t, err, raft.ErrNotLeader)
+
+	servers, err := logs[1].GetServers()
+	require.NoError(t, err)
+	require.Len(t, servers, 3)
+	for i, srv := range servers {
+		require.Equal(t, fmt.Sprintf("%d", i), srv.Id)
+		require.Equal(t, logs[i].config.StreamLayer.Addr().String(), srv.RpcAddr)
+		require.Equal(t, i == 0, srv.Writable)
+	}
+}
+
+func testDistributedLeave(t *testing.T, logs []*DistributedLog) {
+	record := &log_v1.Record{Value: []byte("first")}
+	off, err := logs[0].Append(record)
+	require.NoError(t, err)
+	requireReplicated(t, logs, []*log_v1.Record{record}, []uint64{off})
+
+	require.NoError(t, logs[0].Leave("1"))
+	off, err = logs[0].Append(&log_v1.Record{Value: []byte("second")})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		_, err := logs[2].Read(off)
+		return err == nil
+	}, 3*time.Second, 20*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	_, err = logs[1].Read(off)
+	require.IsType(t, log_v1.ErrOffsetOutOfRange{}, err)
+}
+
+func testDistributedFailover(t *testing.T, logs []*DistributedLog) {
+	type appended struct {
+		record *log_v1.Record
+		offset uint64
+	}
+	done := make(chan []appended)
+	stop := make(chan struct{})
+	go func() {
+		var acked []appended
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				done <- acked
+				return
+			default:
+			}
+			//whoever leads takes the record, retries after the leader died may store it twice but never ack it twice
+			record := &log_v1.Record{Value: []byte(fmt.Sprintf("record %d", i))}
+			for _, l := range logs {
+				if off, err := l.Append(record); err == nil {
+					acked = append(acked, appended{record, off})
+					break
+				}
+			}
+		}
+	}()
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, logs[0].Close())
+	require.NoError(t, logs[1].WaitForLeader(3*time.Second))
+	time.Sleep(200 * time.Millisecond)
+	close(stop)
+	acked := <-done
+
+	var records []*log_v1.Record
+	var offsets []uint64
+	seen := make(map[uint64]bool)
+	for _, a := range acked {
+		require.False(t, seen[a.offset], "offset %d acknowledged twice", a.offset)
+		seen[a.offset] = true
+		records, offsets = append(records, a.record), append(offsets, a.offset)
+	}
+	require.NotEmpty(t, offsets)
+	require.Greater(t, offsets[len(offsets)-1], uint64(0))
+	requireReplicated(t, logs[1:], records, offsets)
+}
+
+func testDistributedRestart(t *testing.T, logs []*DistributedLog) {
+	var records []*log_v1.Record
+	var offsets []uint64
+	appendN := func(n int) {
+		for i := 0; i < n; i++ {
+			record := &log_v1.Record{Value: []byte(fmt.Sprintf("record %d", len(records)))}
+			off, err := logs[0].Append(record)
+			require.NoError(t, err)
+			records, offsets = append(records, record), append(offsets, off)
+		}
+	}
+	appendN(20)
+	requireReplicated(t, logs, records, offsets)
+	dir, config := logs[2].dataDir, logs[2].config
+	require.NoError(t, logs[2].Close())
+
+	//the node misses records that the leader then compacts away behind a snapshot
+	appendN(20)
+	require.NoError(t, logs[0].raft.Snapshot().Error())
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	config.StreamLayer = NewStreamLayer(ln)
+	logs[2], err = NewDistributedLog(dir, config)
+	require.NoError(t, err)
+	require.NoError(t, logs[0].Join("2", ln.Addr().String()))
+	appendN(5)
+	requireReplicated(t, logs, records, offsets)
+}
+
+func TestDistributedLogRequiresConfig(t *testing.T) {
+	dir, err := os.MkdirTemp("", "distributed-log-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	var c DistributedConfig
+	c.Raft.LocalID = "0"
+	_, err = NewDistributedLog(dir, c)
+	require.EqualError(t, err, "distributed log: no stream layer")
+
+	c = DistributedConfig{StreamLayer: NewStreamLayer(ln)}
+	_, err = NewDistributedLog(dir, c)
+	require.EqualError(t, err, "distributed log: no local ID")
+}
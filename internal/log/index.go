@@ -4,6 +4,7 @@ import (
 	"github.com/tysonmote/gommap"
 	"io"
 	"os"
+	"sort"
 )
 
 var (
@@ -38,11 +39,15 @@ func newIndex(f *os.File, config Config) (*index, error) {
 	return idx, nil
 }
 
-func (i *index) Close() error {
+func (i *index) Sync() error {
 	if err := i.mmap.Sync(gommap.MS_SYNC); err != nil {
 		return err
 	}
-	if err := i.file.Sync(); err != nil {
+	return i.file.Sync()
+}
+
+func (i *index) Close() error {
+	if err := i.Sync(); err != nil {
 		return err
 	}
 	if err := i.file.Truncate(int64(i.size)); err != nil {
@@ -70,6 +75,19 @@ func (i *index) Read(in int64) (out uint32, pos uint64, err error) {
 	return out, pos, nil
 }
 
+// Search returns the last entry whose relative offset is not greater than off, i.e. the batch holding off
+func (i *index) Search(off uint32) (out uint32, pos uint64, err error) {
+	entries := int(i.size / entWidth)
+	j := sort.Search(entries, func(j int) bool {
+		p := uint64(j) * entWidth
+		return enc.Uint32(i.mmap[p:p+offWidth]) > off
+	})
+	if j == 0 {
+		return 0, 0, io.EOF
+	}
+	return i.Read(int64(j - 1))
+}
+
 func (i *index) Write(off uint32, pos uint64) error {
 	if uint64(len(i.mmap)) < i.size + entWidth {
 		return io.EOF
@@ -50,3 +50,26 @@ func TestIndex(t *testing.T) {
 	require.Equal(t, entries[len(entries)-1].Pos, pos)
 	require.Equal(t, uint32(len(entries)-1), out)
 }
+
+func TestIndexSearch(t *testing.T) {
+	f, err := os.CreateTemp("", "index_search_test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = 1024
+	idx, err := newIndex(f, c)
+	require.NoError(t, err)
+	_, _, err = idx.Search(0)
+	require.Equal(t, io.EOF, err)
+
+	//batches starting at relative offsets 0, 3 and 10
+	require.NoError(t, idx.Write(0, 0))
+	require.NoError(t, idx.Write(3, 100))
+	require.NoError(t, idx.Write(10, 200))
+	for off, want := range map[uint32]uint64{0: 0, 2: 0, 3: 100, 9: 100, 10: 200, 500: 200} {
+		_, pos, err := idx.Search(off)
+		require.NoError(t, err)
+		require.Equal(t, want, pos)
+	}
+}
@@ -0,0 +1,35 @@
+//go:build go1.23
+
+package log
+
+import (
+	"context"
+	log_v1 "github.com/mishamolnar/proglog/api/v1"
+	"iter"
+)
+
+// All returns the records from offset from to the end of the log, an error ends the sequence
+func (l *Log) All(from uint64) iter.Seq2[*log_v1.Record, error] {
+	return l.seq(context.Background(), from, false)
+}
+
+// Follow returns the records from offset from on, waiting for new ones until ctx is done
+func (l *Log) Follow(ctx context.Context, from uint64) iter.Seq2[*log_v1.Record, error] {
+	return l.seq(ctx, from, true)
+}
+
+func (l *Log) seq(ctx context.Context, from uint64, follow bool) iter.Seq2[*log_v1.Record, error] {
+	return func(yield func(*log_v1.Record, error) bool) {
+		it := l.newIterator(ctx, from)
+		it.Follow = follow
+		defer it.Close()
+		for it.Next() {
+			if !yield(it.Record(), nil) {
+				return
+			}
+		}
+		if err := it.Err(); err != nil {
+			yield(nil, err)
+		}
+	}
+}
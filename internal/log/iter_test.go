@@ -0,0 +1,49 @@
+//go:build go1.23
+
+package log
+
+import (
+	"context"
+	log_v1 "github.com/mishamolnar/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestIterSeq(t *testing.T) {
+	dir, err := os.MkdirTemp("", "iter-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	var c Config
+	c.Segment.MaxStoreBytes = 64
+	l, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer l.Close()
+	appendValues(t, l, 0, 20)
+
+	var offsets []uint64
+	for rec, err := range l.All(5) {
+		require.NoError(t, err)
+		offsets = append(offsets, rec.Offset)
+		if rec.Offset == 9 {
+			break
+		}
+	}
+	require.Equal(t, []uint64{5, 6, 7, 8, 9}, offsets)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	appended := make(chan error, 1)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_, err := l.Append(&log_v1.Record{Value: []byte("tail")})
+		appended <- err
+	}()
+	for rec, err := range l.Follow(ctx, 20) {
+		require.NoError(t, err)
+		require.Equal(t, []byte("tail"), rec.Value)
+		cancel()
+	}
+	require.NoError(t, <-appended)
+}
@@ -0,0 +1,106 @@
+package log
+
+import (
+	"context"
+	log_v1 "github.com/mishamolnar/proglog/api/v1"
+)
+
+// iteratorBatchBytes is how much an Iterator reads from the log at once
+const iteratorBatchBytes = 64 << 10
+
+// Iterator walks a Log's records in offset order, reading them from the segments in batches. Records removed by
+// Truncate or retention while iterating are skipped. An Iterator is not safe for concurrent use, except for Close
+//
+//	it := l.NewIterator(0)
+//	defer it.Close()
+//	for it.Next() {
+//		rec := it.Record()
+//	}
+//	if err := it.Err(); err != nil {
+//		return err
+//	}
+type Iterator struct {
+	// Follow makes Next wait for new records at the end of the log instead of returning false, until Close
+	Follow bool
+	log    *Log
+	ctx    context.Context
+	cancel context.CancelFunc
+	next   uint64
+	buf    []*log_v1.Record
+	rec    *log_v1.Record
+	err    error
+}
+
+func (l *Log) NewIterator(from uint64) *Iterator {
+	return l.newIterator(context.Background(), from)
+}
+
+func (l *Log) newIterator(ctx context.Context, from uint64) *Iterator {
+	ctx, cancel := context.WithCancel(ctx)
+	return &Iterator{log: l, ctx: ctx, cancel: cancel, next: from}
+}
+
+// Next moves to the next record, returning false at the end of the log, on an error or once closed
+func (it *Iterator) Next() bool {
+	for len(it.buf) == 0 {
+		if it.err != nil || it.ctx.Err() != nil {
+			return false
+		}
+		records, end, err := it.log.readRange(it.next, 0, iteratorBatchBytes)
+		if _, ok := err.(log_v1.ErrOffsetOutOfRange); ok {
+			if !it.skip(end) {
+				return false
+			}
+			continue
+		}
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.buf = records
+	}
+	it.rec, it.buf = it.buf[0], it.buf[1:]
+	it.next = it.rec.Offset + 1
+	return true
+}
+
+// skip moves past offsets that hold no records when there is nothing at next, end being the log's next offset
+// at the time. It returns false when the iterator stops at the end of the log
+func (it *Iterator) skip(end uint64) bool {
+	lowest, err := it.log.LowestOffset()
+	if err != nil {
+		it.err = err
+		return false
+	}
+	switch {
+	case it.next < lowest: //truncated away
+		it.next = lowest
+		return true
+	case it.next < end: //compaction left a gap up to the end
+		it.next = end
+		return true
+	case !it.Follow:
+		return false
+	}
+	if err := it.log.Wait(it.ctx, it.next); err != nil {
+		if it.ctx.Err() == nil {
+			it.err = err
+		}
+		return false
+	}
+	return true
+}
+
+func (it *Iterator) Record() *log_v1.Record {
+	return it.rec
+}
+
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Close stops the iterator, waking up a Next waiting for records
+func (it *Iterator) Close() error {
+	it.cancel()
+	return nil
+}
@@ -0,0 +1,126 @@
+package log
+
+import (
+	log_v1 "github.com/mishamolnar/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestIterator(t *testing.T) {
+	for scenario, fn := range map[string]func(t *testing.T, l *Log){
+		"iterate across segments":         testIterate,
+		"follow the tail":                 testIterateFollow,
+		"truncate while iterating":        testIterateTruncate,
+		"close wakes up a following Next": testIterateClose,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "iterator-test")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+			var c Config
+			c.Segment.MaxStoreBytes = 64
+			l, err := NewLog(dir, c)
+			require.NoError(t, err)
+			defer l.Close()
+			fn(t, l)
+		})
+	}
+}
+
+func appendValues(t *testing.T, l *Log, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		_, err := l.Append(&log_v1.Record{Value: []byte{byte(i)}})
+		require.NoError(t, err)
+	}
+}
+
+func testIterate(t *testing.T, l *Log) {
+	appendValues(t, l, 0, 50)
+	require.Greater(t, len(l.segments), 5)
+	it := l.NewIterator(10)
+	defer it.Close()
+	want := uint64(10)
+	for it.Next() {
+		require.Equal(t, want, it.Record().Offset)
+		require.Equal(t, []byte{byte(want)}, it.Record().Value)
+		want++
+	}
+	require.NoError(t, it.Err())
+	require.Equal(t, uint64(50), want)
+
+	//an iterator at the end of the log stops right away
+	it = l.NewIterator(50)
+	require.False(t, it.Next())
+	require.NoError(t, it.Err())
+}
+
+func testIterateFollow(t *testing.T, l *Log) {
+	appendValues(t, l, 0, 5)
+	it := l.NewIterator(0)
+	it.Follow = true
+	defer it.Close()
+	appended := make(chan error, 1)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		for i := 5; i < 30; i++ { //rolls segments under the iterator
+			if _, err := l.Append(&log_v1.Record{Value: []byte{byte(i)}}); err != nil {
+				appended <- err
+				return
+			}
+		}
+		appended <- nil
+	}()
+	for want := uint64(0); want < 30; want++ {
+		require.True(t, it.Next())
+		require.Equal(t, want, it.Record().Offset)
+	}
+	require.NoError(t, <-appended)
+}
+
+func testIterateTruncate(t *testing.T, l *Log) {
+	appendValues(t, l, 0, 50)
+	fresh := l.NewIterator(0)
+	defer fresh.Close()
+	reading := l.NewIterator(0)
+	defer reading.Close()
+	require.True(t, reading.Next())
+
+	require.NoError(t, l.Truncate(30))
+	lowest, err := l.LowestOffset()
+	require.NoError(t, err)
+	require.Greater(t, lowest, uint64(0))
+
+	//records gone before they were read are skipped
+	require.True(t, fresh.Next())
+	require.Equal(t, lowest, fresh.Record().Offset)
+
+	//the batch already read stays readable after its segments are removed
+	prev := reading.Record().Offset
+	for reading.Next() {
+		require.Equal(t, prev+1, reading.Record().Offset)
+		prev = reading.Record().Offset
+	}
+	require.NoError(t, reading.Err())
+	require.Equal(t, uint64(49), prev)
+}
+
+func testIterateClose(t *testing.T, l *Log) {
+	it := l.NewIterator(0)
+	it.Follow = true
+	done := make(chan bool)
+	go func() {
+		done <- it.Next()
+	}()
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, it.Close())
+	select {
+	case next := <-done:
+		require.False(t, next)
+	case <-time.After(time.Second):
+		t.Fatal("Next kept waiting after Close")
+	}
+	require.NoError(t, it.Err())
+}
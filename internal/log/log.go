@@ -1,22 +1,42 @@
 package log
 
 import (
+	"context"
+	"errors"
 	log_v1 "github.com/mishamolnar/proglog/api/v1"
+	"google.golang.org/protobuf/proto"
 	"io"
+	"math"
 	"os"
 	"path"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
+// ErrClosed is returned by a log, or a store of it, that was closed under the caller, as when its topic was deleted
+var ErrClosed = errors.New("log: closed")
+
 type Log struct {
 	mu            sync.RWMutex
 	Dir           string
 	Config        Config
 	activeSegment *segment
 	segments      []*segment
+	recovered     []Recovery
+	unsynced      uint64 //bytes appended to the active segment since its last sync
+	syncErr       error  //the last failed background sync, returned by the next Sync or Close
+	commitMu      sync.Mutex
+	pending       []*pendingAppend
+	committing    bool
+	compactMu     sync.Mutex    //one compaction at a time, they share the scratch directory
+	appended      chan struct{} //closed and replaced after every append to wake up Wait, left closed by Close
+	closed        bool
+	now           func() time.Time
+	done          chan struct{}
+	wg            sync.WaitGroup
 }
 
 func NewLog(dir string, c Config) (*Log, error) {
@@ -26,8 +46,62 @@ func NewLog(dir string, c Config) (*Log, error) {
 	if c.Segment.MaxIndexBytes == 0 {
 		c.Segment.MaxIndexBytes = 1024
 	}
-	l := Log{Dir: dir, Config: c}
-	return &l, l.setup()
+	if c.Segment.TimeIndexIntervalBytes == 0 {
+		c.Segment.TimeIndexIntervalBytes = 4096
+	}
+	if c.Retention.CheckInterval == 0 {
+		c.Retention.CheckInterval = time.Minute
+	}
+	if c.Compaction.TombstoneRetention == 0 {
+		c.Compaction.TombstoneRetention = 24 * time.Hour
+	}
+	l := Log{Dir: dir, Config: c, now: time.Now, appended: make(chan struct{})}
+	if err := l.setup(); err != nil {
+		return nil, err
+	}
+	l.startBackground()
+	return &l, nil
+}
+
+func (l *Log) startBackground() {
+	l.done = make(chan struct{})
+	if l.Config.Durability.SyncInterval > 0 {
+		l.wg.Add(1)
+		go l.syncPeriodically(l.Config.Durability.SyncInterval)
+	}
+	if l.Config.Retention.MaxAge > 0 || l.Config.Retention.MaxBytes > 0 {
+		l.wg.Add(1)
+		go l.retainPeriodically(l.Config.Retention.CheckInterval)
+	}
+}
+
+func (l *Log) stopBackground() {
+	if l.done == nil {
+		return
+	}
+	close(l.done)
+	l.wg.Wait()
+	l.done = nil
+}
+
+// syncPeriodically is the background flusher for Durability.SyncInterval. A failed sync is retried on the next
+// tick and kept for the next Sync or Close to return, as nobody is waiting on the tick itself.
+func (l *Log) syncPeriodically(interval time.Duration) {
+	defer l.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			l.mu.Lock()
+			if err := l.sync(); err != nil {
+				l.syncErr = err
+			}
+			l.mu.Unlock()
+		}
+	}
 }
 
 func (l *Log) setup() error {
@@ -37,20 +111,33 @@ func (l *Log) setup() error {
 	}
 	var baseOffsets []uint64
 	for i := range files {
-		offStr := strings.TrimSuffix(
-			files[i].Name(),
-			path.Ext(files[i].Name()),
-		)
-		off, _ := strconv.ParseUint(offStr, 10, 0)
+		if path.Ext(files[i].Name()) != ".store" { //index files are rebuilt from the store when missing
+			continue
+		}
+		off, err := strconv.ParseUint(strings.TrimSuffix(files[i].Name(), ".store"), 10, 0)
+		if err != nil {
+			continue
+		}
 		baseOffsets = append(baseOffsets, off)
 	}
 	sort.Slice(baseOffsets, func(i, j int) bool {
 		return baseOffsets[i] < baseOffsets[j]
 	})
-	for i := 0; i < len(baseOffsets); i += 2 { //+2 because each base offset occurs in index and segment
-		if err = l.newSegment(baseOffsets[i]); err != nil {
+	l.recovered = nil
+	for i, off := range baseOffsets {
+		end := uint64(0)
+		if i+1 < len(baseOffsets) {
+			end = baseOffsets[i+1]
+		}
+		s, err := openSegment(l.Dir, off, l.Config, end)
+		if err != nil {
 			return err
 		}
+		l.segments = append(l.segments, s)
+		l.activeSegment = s
+		if r := s.recovery; r.Repaired() {
+			l.recovered = append(l.recovered, r)
+		}
 	}
 	if l.segments == nil {
 		if err = l.newSegment(l.Config.Segment.InitialOffset); err != nil {
@@ -60,6 +147,13 @@ func (l *Log) setup() error {
 	return nil
 }
 
+// Recovered reports the segments that had to be repaired when the log was opened
+func (l *Log) Recovered() []Recovery {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.recovered
+}
+
 func (l *Log) newSegment(baseOffset uint64) error {
 	s, err := newSegment(l.Dir, baseOffset, l.Config)
 	if err != nil {
@@ -70,44 +164,250 @@ func (l *Log) newSegment(baseOffset uint64) error {
 	return nil
 }
 
+// Append writes the record to the active segment and returns its offset once it is as durable as
+// Config.Durability asks. Concurrent callers are committed together, see groupCommit.
+// A record without a timestamp is stamped with the time it is committed.
 func (l *Log) Append(record *log_v1.Record) (uint64, error) {
+	return l.groupCommit([]*log_v1.Record{record})
+}
+
+// AppendBatch stores the records as a single batch with consecutive offsets and returns the offset of the first
+// one. Each record can still be read on its own through Read.
+func (l *Log) AppendBatch(records []*log_v1.Record) (uint64, error) {
+	if len(records) == 0 {
+		return 0, errors.New("log: empty batch")
+	}
+	return l.groupCommit(records)
+}
+
+// AppendAt stores records that already carry their offsets, the way a mirror copies another log. next is where
+// the caller expects the log to end and the records must follow on from it without gaps; see AppendRange for
+// copying a source with gaps. An empty log moves its start forward to next. The records are stored as one batch
+// with their offsets and timestamps as they are.
+func (l *Log) AppendAt(next uint64, records []*log_v1.Record) error {
+	return l.appendAt(next, records, false)
+}
+
+// AppendRange is AppendAt for records that are everything a source holds from next up to the last of them, the
+// way a Fetch starting at next returns them. Offsets missing among them are gaps the source has, like those
+// compaction leaves, and are kept as gaps.
+func (l *Log) AppendRange(next uint64, records []*log_v1.Record) error {
+	return l.appendAt(next, records, true)
+}
+
+func (l *Log) appendAt(next uint64, records []*log_v1.Record, gaps bool) error {
+	if len(records) == 0 {
+		return errors.New("log: empty batch")
+	}
+	if first := records[0].Offset; first < next || first > next && !gaps {
+		return log_v1.ErrOffsetMismatch{Expected: next, Offset: first}
+	}
+	for i, r := range records[1:] {
+		if r.Offset <= records[i].Offset || r.Offset > records[i].Offset+1 && !gaps {
+			return log_v1.ErrOffsetMismatch{Expected: records[i].Offset + 1, Offset: r.Offset}
+		}
+	}
 	l.mu.Lock()
 	defer l.mu.Unlock()
-	offset, err := l.activeSegment.Append(record)
-	if err != nil {
-		return 0, err
+	if l.closed {
+		return ErrClosed
+	}
+	end := l.activeSegment.nextOffset
+	empty := len(l.segments) == 1 && l.activeSegment.store.size == 0
+	if next != end && (!empty || next < end) {
+		return log_v1.ErrOffsetMismatch{Expected: end, Offset: next}
+	}
+	first := records[0].Offset
+	if next != end {
+		if err := l.activeSegment.Remove(); err != nil {
+			return err
+		}
+		l.segments = nil
+		if err := l.newSegment(next); err != nil {
+			return err
+		}
+	} else if first-l.activeSegment.baseOffset > math.MaxUint32 { //relative offsets in the index are 32 bits wide
+		if err := l.activeSegment.Sync(); err != nil {
+			return err
+		}
+		if err := l.newSegment(first); err != nil {
+			return err
+		}
+	}
+	size := l.activeSegment.store.size
+	if err := l.activeSegment.appendBatch(&log_v1.RecordBatch{BaseOffset: first, Records: records}); err != nil {
+		return err
 	}
+	l.unsynced += l.activeSegment.store.size - size
+	close(l.appended)
+	l.appended = make(chan struct{})
 	if l.activeSegment.IsMaxed() {
-		err = l.newSegment(offset + 1)
+		if err := l.activeSegment.Sync(); err != nil {
+			return err
+		}
+		l.unsynced = 0
+		return l.newSegment(l.activeSegment.nextOffset)
+	}
+	d := l.Config.Durability
+	if d.SyncEveryAppend || d.SyncEveryNBytes > 0 && l.unsynced >= d.SyncEveryNBytes {
+		return l.sync()
+	}
+	return nil
+}
+
+// Sync commits everything appended so far to stable storage. Segments other than the active one were synced
+// when they rolled.
+func (l *Log) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := l.sync()
+	if l.syncErr != nil {
+		err, l.syncErr = l.syncErr, nil
+	}
+	return err
+}
+
+func (l *Log) sync() error {
+	if err := l.activeSegment.Sync(); err != nil {
+		return err
 	}
-	return offset, nil
+	l.unsynced = 0
+	return nil
 }
 
+// Read returns the record at off. When compaction removed it, the next record still in the log is returned
+// instead, so callers walking the log should continue after the offset of the record they got.
 func (l *Log) Read(off uint64) (*log_v1.Record, error) {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
-	var s *segment
-	for _, currSegment := range l.segments {
-		if currSegment.baseOffset <= off && off < currSegment.nextOffset {
-			s = currSegment
+	if l.closed {
+		return nil, ErrClosed
+	}
+	if off < l.segments[0].baseOffset {
+		return nil, log_v1.ErrOffsetOutOfRange{Offset: off}
+	}
+	for _, s := range l.segments {
+		if s.nextOffset <= off {
+			continue
+		}
+		rec, err := s.Read(max(off, s.baseOffset))
+		if err == io.EOF { //compacted away up to the end of the segment
+			continue
+		}
+		return rec, err
+	}
+	return nil, log_v1.ErrOffsetOutOfRange{Offset: off}
+}
+
+// ReadRange returns the records from off on, at most maxRecords of them holding at most maxBytes bytes though
+// always at least one, zero meaning no limit. Each segment is read front to back rather than looking every
+// offset up on its own
+func (l *Log) ReadRange(off uint64, maxRecords int, maxBytes uint64) ([]*log_v1.Record, error) {
+	records, _, err := l.readRange(off, maxRecords, maxBytes)
+	return records, err
+}
+
+// readRange is ReadRange that also returns the log's next offset as it was during the read
+func (l *Log) readRange(off uint64, maxRecords int, maxBytes uint64) ([]*log_v1.Record, uint64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	next := l.activeSegment.nextOffset
+	if l.closed {
+		return nil, next, ErrClosed
+	}
+	if off < l.segments[0].baseOffset {
+		return nil, next, log_v1.ErrOffsetOutOfRange{Offset: off}
+	}
+	var records []*log_v1.Record
+	var size uint64
+	full := false
+	for _, s := range l.segments {
+		if s.nextOffset <= off {
+			continue
+		}
+		err := s.scan(max(off, s.baseOffset), func(rec *log_v1.Record) bool {
+			n := uint64(proto.Size(rec))
+			if maxBytes > 0 && len(records) > 0 && size+n > maxBytes {
+				full = true
+				return false
+			}
+			records = append(records, rec)
+			size += n
+			full = maxRecords > 0 && len(records) >= maxRecords
+			return !full
+		})
+		if err != nil {
+			return nil, next, err
+		}
+		if full {
 			break
 		}
 	}
-	if s == nil || s.nextOffset <= off {
-		return nil, log_v1.ErrOffsetOutOfRange{Offset: off}
+	if len(records) == 0 {
+		return nil, next, log_v1.ErrOffsetOutOfRange{Offset: off}
 	}
-	return s.Read(off)
+	return records, next, nil
 }
 
+// Wait blocks until the log holds offsets at or past off, or ctx is done
+func (l *Log) Wait(ctx context.Context, off uint64) error {
+	for {
+		l.mu.RLock()
+		next, appended, closed := l.activeSegment.nextOffset, l.appended, l.closed
+		l.mu.RUnlock()
+		if closed {
+			return ErrClosed
+		}
+		if next > off {
+			return nil
+		}
+		select {
+		case <-appended:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// OffsetForTime returns the offset of the first record whose timestamp is not older than t, or the offset the
+// next record will get when every record is older
+func (l *Log) OffsetForTime(t time.Time) (uint64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, s := range l.segments {
+		off, ok, err := s.offsetForTime(t.UnixNano())
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			return off, nil
+		}
+	}
+	return l.activeSegment.nextOffset, nil
+}
+
+// Close closes the segments after syncing the active one. Appends and reads fail from then on with ErrClosed,
+// waiters included
 func (l *Log) Close() error {
+	l.stopBackground()
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	close(l.appended)
+	if err := l.sync(); err != nil && l.syncErr == nil {
+		l.syncErr = err
+	}
 	for _, s := range l.segments {
 		if err := s.Close(); err != nil {
 			return err
 		}
 	}
-	return nil
+	err := l.syncErr
+	l.syncErr = nil
+	return err
 }
 
 func (l *Log) Remove() error {
@@ -121,7 +421,12 @@ func (l *Log) Reset() error {
 	if err := l.Remove(); err != nil {
 		return err
 	}
-	return l.setup()
+	l.closed, l.appended = false, make(chan struct{})
+	if err := l.setup(); err != nil {
+		return err
+	}
+	l.startBackground()
+	return nil
 }
 
 func (l *Log) LowestOffset() (uint64, error) {
@@ -130,11 +435,57 @@ func (l *Log) LowestOffset() (uint64, error) {
 	return l.segments[0].baseOffset, nil
 }
 
+// HighestOffset returns the offset of the last record, 0 for a log that starts at 0 and is still empty
 func (l *Log) HighestOffset() (uint64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	off := l.segments[len(l.segments)-1].nextOffset
+	if off == 0 {
+		return 0, nil
+	}
+	return off - 1, nil
+}
+
+// NextOffset returns the offset the next appended record gets, the end of the log to consumers
+func (l *Log) NextOffset() (uint64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.segments[len(l.segments)-1].nextOffset, nil
+}
+
+// truncateFrom removes the records from off on, the next append gets off
+func (l *Log) truncateFrom(off uint64) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
-	off := l.segments[len(l.segments)-1].nextOffset
-	return max(off-1, 0), nil
+	if off < l.segments[0].baseOffset {
+		return log_v1.ErrOffsetOutOfRange{Offset: off}
+	}
+	var segments []*segment
+	for _, s := range l.segments {
+		if s.baseOffset >= off && len(segments) > 0 {
+			if err := s.Remove(); err != nil {
+				return err
+			}
+			continue
+		}
+		segments = append(segments, s)
+	}
+	l.segments = segments
+	l.activeSegment = segments[len(segments)-1]
+	return l.activeSegment.truncateFrom(off)
+}
+
+// resetTo removes every record, the next append gets off
+func (l *Log) resetTo(off uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, s := range l.segments {
+		if err := s.Remove(); err != nil {
+			return err
+		}
+	}
+	l.segments = nil
+	return l.newSegment(off)
 }
 
 // Truncate truncates start of the log and removes each segment that ends with offset less than lowest
@@ -155,15 +506,32 @@ func (l *Log) Truncate(lowest uint64) error {
 	return nil
 }
 
+// originReader streams the raw frames of a segment's store, verifying each checksum on the way
 type originReader struct {
-	*store
-	off int64
+	*segment
+	entry int64
+	buf   []byte
 }
 
 func (o *originReader) Read(p []byte) (int, error) {
-	n, err := o.ReadAt(p, o.off)
-	o.off += int64(n)
-	return n, err
+	if len(o.buf) == 0 {
+		off, pos, err := o.index.Read(o.entry)
+		if err != nil {
+			return 0, err
+		}
+		frame, err := o.store.frame(pos)
+		if errors.Is(err, errChecksum) {
+			return 0, log_v1.ErrCorruptRecord{Offset: o.baseOffset + uint64(off), Segment: o.baseOffset}
+		}
+		if err != nil {
+			return 0, err
+		}
+		o.buf = frame
+		o.entry++
+	}
+	n := copy(p, o.buf)
+	o.buf = o.buf[n:]
+	return n, nil
 }
 
 func (l *Log) Reader() io.Reader {
@@ -171,7 +539,7 @@ func (l *Log) Reader() io.Reader {
 	defer l.mu.Unlock()
 	readers := make([]io.Reader, len(l.segments))
 	for i, s := range l.segments {
-		readers[i] = &originReader{s.store, 0}
+		readers[i] = &originReader{segment: s}
 	}
 	return io.MultiReader(readers...)
 }
@@ -1,12 +1,16 @@
 package log
 
 import (
+	"context"
+	"fmt"
 	log_v1 "github.com/mishamolnar/proglog/api/v1"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/protobuf/proto"
 	"io"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestLog(t *testing.T) {
@@ -16,6 +20,14 @@ func TestLog(t *testing.T) {
 		"init with existing segments":         testInitExisting,
 		"test log reader":                     testReader,
 		"test truncate log":                   testTruncate,
+		"corrupt record error":                testCorruptRecord,
+		"append batch and read its records":   testAppendBatch,
+		"keys, headers and timestamps":        testTimestamp,
+		"offset for time":                     testOffsetForTime,
+		"wait for appends":                    testWait,
+		"read a range across segments":        testReadRange,
+		"append at given offsets":             testAppendAt,
+		"a closed log fails everyone":         testClosed,
 	} {
 		t.Run(scenario, func(t *testing.T) {
 			tmpDir, err := os.MkdirTemp("", "store-test")
@@ -30,6 +42,140 @@ func TestLog(t *testing.T) {
 	}
 }
 
+func testReadRange(t *testing.T, log *Log) {
+	for i := 0; i < 10; i++ {
+		_, err := log.Append(&log_v1.Record{Value: []byte{byte(i)}, Timestamp: 1})
+		require.NoError(t, err)
+	}
+	require.Greater(t, len(log.segments), 2)
+
+	records, err := log.ReadRange(2, 0, 0)
+	require.NoError(t, err)
+	require.Len(t, records, 8)
+	for i, rec := range records {
+		require.Equal(t, uint64(i+2), rec.Offset)
+		require.Equal(t, []byte{byte(i + 2)}, rec.Value)
+	}
+
+	records, err = log.ReadRange(3, 4, 0)
+	require.NoError(t, err)
+	require.Len(t, records, 4)
+	require.Equal(t, uint64(6), records[3].Offset)
+
+	size := uint64(proto.Size(records[0]))
+	records, err = log.ReadRange(0, 0, 3*size)
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	//a single record bigger than the limit still comes back
+	records, err = log.ReadRange(9, 0, 1)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+
+	_, err = log.ReadRange(10, 0, 0)
+	require.Equal(t, log_v1.ErrOffsetOutOfRange{Offset: 10}, err)
+}
+
+func testWait(t *testing.T, log *Log) {
+	ctx := context.Background()
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, log.Wait(timeout, 0), context.DeadlineExceeded)
+	_, err := log.Append(&log_v1.Record{Value: []byte("first")})
+	require.NoError(t, err)
+	require.NoError(t, log.Wait(ctx, 0))
+
+	waited := make(chan error)
+	go func() {
+		waited <- log.Wait(ctx, 1)
+	}()
+	select {
+	case <-waited:
+		t.Fatal("wait returned before the append")
+	case <-time.After(10 * time.Millisecond):
+	}
+	_, err = log.Append(&log_v1.Record{Value: []byte("second")})
+	require.NoError(t, err)
+	require.NoError(t, <-waited)
+}
+
+func testClosed(t *testing.T, log *Log) {
+	_, err := log.Append(&log_v1.Record{Value: []byte("first")})
+	require.NoError(t, err)
+	waited := make(chan error)
+	go func() {
+		waited <- log.Wait(context.Background(), 1)
+	}()
+	store := log.activeSegment.store
+	require.NoError(t, log.Close())
+	select {
+	case err = <-waited:
+		require.ErrorIs(t, err, ErrClosed)
+	case <-time.After(time.Second):
+		t.Fatal("Wait kept waiting on a closed log")
+	}
+	_, err = log.Append(&log_v1.Record{Value: []byte("second")})
+	require.ErrorIs(t, err, ErrClosed)
+	require.ErrorIs(t, log.AppendAt(1, []*log_v1.Record{{Offset: 1}}), ErrClosed)
+	_, err = log.Read(0)
+	require.ErrorIs(t, err, ErrClosed)
+	_, err = log.ReadRange(0, 0, 0)
+	require.ErrorIs(t, err, ErrClosed)
+	_, _, err = store.Append([]byte("late"))
+	require.ErrorIs(t, err, ErrClosed)
+	require.NoError(t, log.Close())
+}
+
+func testAppendAt(t *testing.T, log *Log) {
+	at := func(offsets ...uint64) []*log_v1.Record {
+		records := make([]*log_v1.Record, len(offsets))
+		for i, off := range offsets {
+			records[i] = &log_v1.Record{Value: []byte(fmt.Sprintf("record %d", off)), Offset: off, Timestamp: int64(off + 1)}
+		}
+		return records
+	}
+	//an empty log moves its start to where the caller expects it to end
+	require.NoError(t, log.AppendRange(4, at(5, 6)))
+	lowest, err := log.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), lowest)
+	require.Equal(t, offsetMismatch(7, 4), log.AppendAt(4, at(4)))
+	require.Equal(t, offsetMismatch(7, 4), log.AppendRange(4, at(7)))
+
+	waited := make(chan error)
+	go func() {
+		waited <- log.Wait(context.Background(), 7)
+	}()
+	require.NoError(t, log.AppendAt(7, at(7, 8, 9)))
+	require.NoError(t, <-waited)
+
+	require.Equal(t, offsetMismatch(10, 9), log.AppendAt(9, at(9)))
+	require.Equal(t, offsetMismatch(10, 9), log.AppendAt(10, at(9)))
+	require.Equal(t, offsetMismatch(11, 10), log.AppendAt(10, at(10, 10)))
+	//gaps are rejected unless the caller vouches for them as the source's own
+	require.Equal(t, offsetMismatch(10, 12), log.AppendAt(10, at(12)))
+	require.Equal(t, offsetMismatch(11, 12), log.AppendAt(10, at(10, 12)))
+	require.NoError(t, log.AppendRange(10, at(12, 14)))
+	require.NoError(t, log.AppendAt(15, []*log_v1.Record{{Value: []byte("unstamped"), Offset: 15}}))
+	for _, off := range []uint64{5, 6, 7, 8, 9, 12, 14} {
+		//offsets 4, 11 and 13 read the record after them, the way compacted offsets do
+		read, err := log.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, off, read.Offset)
+		require.Equal(t, []byte(fmt.Sprintf("record %d", off)), read.Value)
+		require.Equal(t, int64(off+1), read.Timestamp)
+	}
+	read, err := log.Read(15)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), read.Timestamp)
+	off, err := log.Append(&log_v1.Record{Value: []byte("appended")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(16), off)
+}
+
+func offsetMismatch(expected, offset uint64) error {
+	return log_v1.ErrOffsetMismatch{Expected: expected, Offset: offset}
+}
+
 func testAppendRead(t *testing.T, log *Log) {
 	appended := &log_v1.Record{Value: []byte("some log to write")}
 	off, err := log.Append(appended)
@@ -44,6 +190,9 @@ func testOutOfRangeErr(t *testing.T, log *Log) {
 	read, err := log.Read(1)
 	require.Nil(t, read)
 	require.Equal(t, log_v1.ErrOffsetOutOfRange{Offset: 1}, err)
+	off, err := log.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), off)
 }
 
 func testInitExisting(t *testing.T, log *Log) {
@@ -77,9 +226,11 @@ func testReader(t *testing.T, log *Log) {
 	b, err := io.ReadAll(log.Reader())
 	require.NoError(t, err)
 
-	read := &log_v1.Record{}
-	err = proto.Unmarshal(b[lenWidth:], read)
+	batch := &log_v1.RecordBatch{}
+	err = proto.Unmarshal(b[frameHeaderWidth:], batch)
 	require.NoError(t, err)
+	require.Len(t, batch.Records, 1)
+	read := batch.Records[0]
 	require.Equal(t, read.Value, appended.Value)
 	require.Equal(t, read.Offset, appended.Offset)
 }
@@ -102,3 +253,252 @@ func testTruncate(t *testing.T, log *Log) {
 	hiOff, err := log.HighestOffset()
 	require.Equal(t, hiOff, uint64(4))
 }
+
+func testAppendBatch(t *testing.T, log *Log) {
+	first, err := log.Append(&log_v1.Record{Value: []byte("single")})
+	require.NoError(t, err)
+	var batch []*log_v1.Record
+	for i := 0; i < 5; i++ {
+		batch = append(batch, &log_v1.Record{Value: []byte{byte(i)}})
+	}
+	base, err := log.AppendBatch(batch)
+	require.NoError(t, err)
+	require.Equal(t, first+1, base)
+	next, err := log.Append(&log_v1.Record{Value: []byte("single")})
+	require.NoError(t, err)
+	require.Equal(t, base+5, next)
+
+	for i, want := range batch {
+		read, err := log.Read(base + uint64(i))
+		require.NoError(t, err)
+		require.Equal(t, base+uint64(i), read.Offset)
+		require.Equal(t, want.Value, read.Value)
+	}
+	_, err = log.Read(next + 1)
+	require.Equal(t, log_v1.ErrOffsetOutOfRange{Offset: next + 1}, err)
+	_, err = log.AppendBatch(nil)
+	require.Error(t, err)
+
+	//the whole batch takes a single index entry
+	entries := uint64(0)
+	for _, s := range log.segments {
+		entries += s.index.size / entWidth
+	}
+	require.Equal(t, uint64(3), entries)
+}
+
+func testTimestamp(t *testing.T, log *Log) {
+	now := time.Unix(1700000000, 0)
+	log.now = func() time.Time { return now }
+	stamped := &log_v1.Record{Value: []byte("stamped"), Timestamp: 42}
+	unstamped := &log_v1.Record{
+		Value:   []byte("unstamped"),
+		Key:     []byte("user-1"),
+		Headers: []*log_v1.Header{{Key: "content-type", Value: []byte("text/plain")}},
+	}
+	for _, r := range []*log_v1.Record{stamped, unstamped} {
+		_, err := log.Append(r)
+		require.NoError(t, err)
+	}
+
+	read, err := log.Read(stamped.Offset)
+	require.NoError(t, err)
+	require.Equal(t, int64(42), read.Timestamp)
+	read, err = log.Read(unstamped.Offset)
+	require.NoError(t, err)
+	require.Equal(t, now.UnixNano(), read.Timestamp)
+	require.Equal(t, unstamped.Key, read.Key)
+	require.Len(t, read.Headers, 1)
+	require.Equal(t, "content-type", read.Headers[0].Key)
+	require.Equal(t, []byte("text/plain"), read.Headers[0].Value)
+}
+
+func testOffsetForTime(t *testing.T, log *Log) {
+	base := time.Unix(1700000000, 0)
+	//an empty log resolves every time to the next offset
+	for _, ts := range []time.Time{time.Unix(0, 0), time.Unix(-1, 0), base} {
+		off, err := log.OffsetForTime(ts)
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), off)
+	}
+	//records spread over several segments, the fourth one arrives late with an older timestamp
+	for _, sec := range []int{0, 10, 20, 15, 30, 40} {
+		_, err := log.Append(&log_v1.Record{
+			Value:     []byte("tick"),
+			Timestamp: base.Add(time.Duration(sec) * time.Second).UnixNano(),
+		})
+		require.NoError(t, err)
+	}
+	require.Greater(t, len(log.segments), 1)
+	lookup := map[int]uint64{-5: 0, 0: 0, 5: 1, 10: 1, 12: 2, 16: 2, 21: 4, 30: 4, 40: 5, 41: 6}
+	check := func(l *Log) {
+		for sec, want := range lookup {
+			off, err := l.OffsetForTime(base.Add(time.Duration(sec) * time.Second))
+			require.NoError(t, err)
+			require.Equal(t, want, off, "lookup %ds", sec)
+		}
+	}
+	check(log)
+
+	//time indexes are rebuilt from the stores when they go missing
+	require.NoError(t, log.Close())
+	files, err := filepath.Glob(filepath.Join(log.Dir, "*.timeindex"))
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+	for _, f := range files {
+		require.NoError(t, os.Remove(f))
+	}
+	l, err := NewLog(log.Dir, log.Config)
+	require.NoError(t, err)
+	require.Len(t, l.Recovered(), len(files)-1) //the active segment is empty, nothing to rebuild there
+	for _, r := range l.Recovered() {
+		require.Equal(t, uint64(1), r.RebuiltTimeEntries)
+	}
+	check(l)
+}
+
+func testCorruptRecord(t *testing.T, log *Log) {
+	appended := &log_v1.Record{Value: []byte("Hello"), Timestamp: 1}
+	for i := 0; i < 2; i++ {
+		_, err := log.Append(appended)
+		require.NoError(t, err)
+	}
+	require.NoError(t, log.Close())
+
+	//damage the last byte of the first record's payload
+	name := filepath.Join(log.Dir, "0.store")
+	f, err := os.OpenFile(name, os.O_RDWR, 0644)
+	require.NoError(t, err)
+	b := make([]byte, 1)
+	pos := int64(batchFrameSize(0, appended.Value) - 1)
+	_, err = f.ReadAt(b, pos)
+	require.NoError(t, err)
+	b[0] ^= 0xff
+	_, err = f.WriteAt(b, pos)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	l, err := NewLog(log.Dir, log.Config)
+	require.NoError(t, err)
+	_, err = l.Read(0)
+	require.Equal(t, log_v1.ErrCorruptRecord{Offset: 0, Segment: 0}, err)
+	read, err := l.Read(1)
+	require.NoError(t, err)
+	require.Equal(t, appended.Value, read.Value)
+
+	_, err = io.ReadAll(l.Reader())
+	require.Equal(t, log_v1.ErrCorruptRecord{Offset: 0, Segment: 0}, err)
+}
+
+func TestLogDurability(t *testing.T) {
+	record := &log_v1.Record{Value: []byte("durable"), Timestamp: 1}
+	//size of the store holding the first n records
+	frames := func(n int) int64 {
+		size := int64(0)
+		for i := 0; i < n; i++ {
+			size += int64(batchFrameSize(uint64(i), record.Value))
+		}
+		return size
+	}
+	for scenario, tc := range map[string]struct {
+		configure func(c *Config)
+		appends   int
+		synced    int
+	}{
+		"nothing synced by default": {configure: func(c *Config) {}, appends: 1, synced: 0},
+		"sync every append": {
+			configure: func(c *Config) { c.Durability.SyncEveryAppend = true },
+			appends:   1,
+			synced:    1,
+		},
+		"sync every n bytes": {
+			configure: func(c *Config) { c.Durability.SyncEveryNBytes = uint64(frames(2)) },
+			appends:   3,
+			synced:    2,
+		},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "durability-test")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+			var c Config
+			tc.configure(&c)
+			l, err := NewLog(dir, c)
+			require.NoError(t, err)
+			for i := 0; i < tc.appends; i++ {
+				_, err = l.Append(&log_v1.Record{Value: record.Value, Timestamp: record.Timestamp})
+				require.NoError(t, err)
+			}
+			require.Equal(t, frames(tc.synced), storeFileSize(t, dir))
+
+			require.NoError(t, l.Sync())
+			require.Equal(t, frames(tc.appends), storeFileSize(t, dir))
+			require.NoError(t, l.Close())
+		})
+	}
+
+	t.Run("sync on interval", func(t *testing.T) {
+		dir, err := os.MkdirTemp("", "durability-test")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+		var c Config
+		c.Durability.SyncInterval = 10 * time.Millisecond
+		l, err := NewLog(dir, c)
+		require.NoError(t, err)
+		_, err = l.Append(&log_v1.Record{Value: record.Value, Timestamp: record.Timestamp})
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			return storeFileSize(t, dir) == frames(1)
+		}, time.Second, 5*time.Millisecond)
+		require.NoError(t, l.Close())
+	})
+
+	t.Run("failed interval syncs surface", func(t *testing.T) {
+		dir, err := os.MkdirTemp("", "durability-test")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+		var c Config
+		c.Durability.SyncInterval = 10 * time.Millisecond
+		l, err := NewLog(dir, c)
+		require.NoError(t, err)
+		_, err = l.Append(&log_v1.Record{Value: record.Value, Timestamp: record.Timestamp})
+		require.NoError(t, err)
+
+		//the file goes away under the flusher, then comes back so only the stored failure is left to report
+		s := l.activeSegment.store
+		s.mu.Lock()
+		f := s.File
+		require.NoError(t, f.Close())
+		s.mu.Unlock()
+		require.Eventually(t, func() bool {
+			l.mu.RLock()
+			defer l.mu.RUnlock()
+			return l.syncErr != nil
+		}, time.Second, 5*time.Millisecond)
+		s.mu.Lock()
+		s.File, err = os.OpenFile(f.Name(), os.O_RDWR|os.O_APPEND, 0644)
+		s.buf.Reset(s.File)
+		s.mu.Unlock()
+		require.NoError(t, err)
+
+		require.ErrorIs(t, l.Sync(), os.ErrClosed)
+		require.NoError(t, l.Sync())
+		require.NoError(t, l.Close())
+	})
+}
+
+func storeFileSize(t *testing.T, dir string) int64 {
+	t.Helper()
+	fi, err := os.Stat(filepath.Join(dir, "0.store"))
+	require.NoError(t, err)
+	return fi.Size()
+}
+
+// batchFrameSize is the size of the store frame holding a batch of the values appended at base with timestamp 1
+func batchFrameSize(base uint64, values ...[]byte) int {
+	batch := &log_v1.RecordBatch{BaseOffset: base}
+	for i, v := range values {
+		batch.Records = append(batch.Records, &log_v1.Record{Value: v, Offset: base + uint64(i), Timestamp: 1})
+	}
+	return frameHeaderWidth + proto.Size(batch)
+}
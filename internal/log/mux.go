@@ -0,0 +1,116 @@
+package log
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// muxPeekTimeout is how long a new connection has to send its first byte
+const muxPeekTimeout = 10 * time.Second
+
+// Mux shares a listener between Raft and an RPC server: connections whose first byte is RaftRPC go to Raft,
+// the StreamLayer built on Raft(), every other one goes to Other(). It is what lets GetServers report the Raft
+// address as the RPC address
+type Mux struct {
+	ln    net.Listener
+	raft  *muxListener
+	other *muxListener
+}
+
+func NewMux(ln net.Listener) *Mux {
+	return &Mux{ln: ln, raft: newMuxListener(ln.Addr()), other: newMuxListener(ln.Addr())}
+}
+
+func (m *Mux) Raft() net.Listener {
+	return m.raft
+}
+
+func (m *Mux) Other() net.Listener {
+	return m.other
+}
+
+// Serve accepts connections until the listener is closed, then closes both of its listeners
+func (m *Mux) Serve() error {
+	defer m.raft.Close()
+	defer m.other.Close()
+	for {
+		conn, err := m.ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go m.route(conn)
+	}
+}
+
+func (m *Mux) Close() error {
+	return m.ln.Close()
+}
+
+func (m *Mux) route(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(muxPeekTimeout))
+	b, err := r.Peek(1)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return
+	}
+	l := m.other
+	if b[0] == RaftRPC {
+		l = m.raft
+	}
+	l.deliver(&peekedConn{Conn: conn, r: r})
+}
+
+// peekedConn reads the bytes Mux peeked at before the rest of the connection
+type peekedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *peekedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+type muxListener struct {
+	addr  net.Addr
+	conns chan net.Conn
+	once  sync.Once
+	done  chan struct{}
+}
+
+func newMuxListener(addr net.Addr) *muxListener {
+	return &muxListener{addr: addr, conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+func (l *muxListener) deliver(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+func (l *muxListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *muxListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *muxListener) Addr() net.Addr {
+	return l.addr
+}
@@ -0,0 +1,47 @@
+package log
+
+import (
+	"hash/fnv"
+	"sync/atomic"
+)
+
+// Partitioner picks which of a topic's n partitions a record with key goes to
+type Partitioner interface {
+	Partition(key []byte, n int) uint32
+}
+
+var (
+	_ Partitioner = (*HashPartitioner)(nil)
+	_ Partitioner = (*RoundRobinPartitioner)(nil)
+	_ Partitioner = ExplicitPartitioner(0)
+)
+
+// HashPartitioner keeps every record of a key in the same partition, keyless records are spread round-robin
+type HashPartitioner struct {
+	keyless RoundRobinPartitioner
+}
+
+func (p *HashPartitioner) Partition(key []byte, n int) uint32 {
+	if len(key) == 0 {
+		return p.keyless.Partition(key, n)
+	}
+	h := fnv.New32a()
+	h.Write(key)
+	return h.Sum32() % uint32(n)
+}
+
+// RoundRobinPartitioner ignores keys and cycles through the partitions
+type RoundRobinPartitioner struct {
+	next atomic.Uint64
+}
+
+func (p *RoundRobinPartitioner) Partition(key []byte, n int) uint32 {
+	return uint32((p.next.Add(1) - 1) % uint64(n))
+}
+
+// ExplicitPartitioner always picks the same partition, the topic rejects it if it has fewer
+type ExplicitPartitioner uint32
+
+func (p ExplicitPartitioner) Partition(key []byte, n int) uint32 {
+	return uint32(p)
+}
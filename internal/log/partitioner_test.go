@@ -0,0 +1,29 @@
+package log
+
+import (
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"testing"
+)
+
+func TestPartitioner(t *testing.T) {
+	var hash HashPartitioner
+	seen := make(map[uint32]bool)
+	for i := 0; i < 100; i++ {
+		key := []byte("user-" + strconv.Itoa(i))
+		p := hash.Partition(key, 4)
+		require.Less(t, p, uint32(4))
+		require.Equal(t, p, hash.Partition(key, 4))
+		seen[p] = true
+	}
+	require.Len(t, seen, 4)
+
+	//keyless records and the round-robin partitioner cycle through every partition
+	for _, p := range []Partitioner{&hash, &RoundRobinPartitioner{}} {
+		for i := 0; i < 6; i++ {
+			require.Equal(t, uint32(i%3), p.Partition(nil, 3))
+		}
+	}
+
+	require.Equal(t, uint32(2), ExplicitPartitioner(2).Partition([]byte("ignored"), 4))
+}
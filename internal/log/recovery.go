@@ -0,0 +1,144 @@
+package log
+
+import (
+	"errors"
+	log_v1 "github.com/mishamolnar/proglog/api/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// Recovery describes what the startup recovery pass repaired in a single segment
+type Recovery struct {
+	BaseOffset uint64
+	// TruncatedBytes is the size of the torn tail cut from the end of the store
+	TruncatedBytes uint64
+	// DroppedEntries counts index entries that did not match a whole frame in the store
+	DroppedEntries uint64
+	// RebuiltEntries counts index entries rewritten from the frames found in the store
+	RebuiltEntries uint64
+	// DroppedTimeEntries and RebuiltTimeEntries are the same for the time index
+	DroppedTimeEntries uint64
+	RebuiltTimeEntries uint64
+}
+
+func (r Recovery) Repaired() bool {
+	return r.TruncatedBytes > 0 || r.DroppedEntries > 0 || r.RebuiltEntries > 0 ||
+		r.DroppedTimeEntries > 0 || r.RebuiltTimeEntries > 0
+}
+
+type indexEntry struct {
+	off uint32
+	pos uint64
+	ts  int64 //newest timestamp in the batch
+	n   uint64
+}
+
+// recover scans the batches of the store, cuts a torn tail and makes the index agree with what is left.
+// A frame failing its checksum in the middle of the store is kept so that readers get ErrCorruptRecord for it,
+// only the last frame of the active segment is considered torn when it does not verify. A closed segment was
+// synced when it rolled, so its damaged tail is kept too and taken to run up to end, where the next segment starts.
+func (s *segment) recover(end uint64) (Recovery, error) {
+	r := Recovery{BaseOffset: s.baseOffset}
+	closed := end > s.baseOffset
+	var entries []indexEntry
+	next := s.baseOffset
+	pos := uint64(0)
+	for pos < s.store.size {
+		n, err := s.store.frameSize(pos)
+		if errors.Is(err, errChecksum) {
+			if closed {
+				entries = append(entries, indexEntry{off: uint32(next - s.baseOffset), pos: pos, n: s.store.size - pos})
+				next, pos = end, s.store.size
+			}
+			break
+		}
+		if err != nil {
+			return r, err
+		}
+		data, err := s.store.Read(pos)
+		if errors.Is(err, errChecksum) && pos+n == s.store.size && !closed {
+			break
+		}
+		batch := &log_v1.RecordBatch{}
+		switch {
+		case errors.Is(err, errChecksum):
+			//the damaged batch covers everything up to the base offset of the next good one
+			batch.BaseOffset = next
+		case err != nil:
+			return r, err
+		default:
+			if err = proto.Unmarshal(data, batch); err != nil {
+				return r, err
+			}
+		}
+		entries = append(entries, indexEntry{
+			off: uint32(batch.BaseOffset - s.baseOffset),
+			pos: pos,
+			ts:  maxTimestamp(batch.Records),
+			n:   n,
+		})
+		if len(batch.Records) > 0 {
+			next = batch.Records[len(batch.Records)-1].Offset + 1 //compacted batches may have gaps
+		}
+		pos += n
+		if closed && errors.Is(err, errChecksum) && pos == s.store.size {
+			next = end
+		}
+	}
+	if pos < s.store.size {
+		r.TruncatedBytes = s.store.size - pos
+		if err := s.store.truncate(pos); err != nil {
+			return r, err
+		}
+	}
+
+	indexed := s.index.size / entWidth
+	valid := uint64(0)
+	for ; valid < indexed && valid < uint64(len(entries)); valid++ {
+		off, p, err := s.index.Read(int64(valid))
+		if err != nil {
+			return r, err
+		}
+		if off != entries[valid].off || p != entries[valid].pos {
+			break
+		}
+	}
+	r.DroppedEntries = indexed - valid
+	r.RebuiltEntries = uint64(len(entries)) - valid
+	s.index.size = valid * entWidth
+	for _, e := range entries[valid:] {
+		if err := s.index.Write(e.off, e.pos); err != nil {
+			return r, err
+		}
+	}
+	s.nextOffset = next
+	return r, s.recoverTimeIndex(entries, &r)
+}
+
+// recoverTimeIndex drops time entries pointing past the end of the segment and rebuilds the time index from
+// the batches when nothing is left of it
+func (s *segment) recoverTimeIndex(batches []indexEntry, r *Recovery) error {
+	kept := len(s.timeIndex.entries)
+	for kept > 0 && s.baseOffset+uint64(s.timeIndex.entries[kept-1].off) >= s.nextOffset {
+		kept--
+	}
+	r.DroppedTimeEntries = uint64(len(s.timeIndex.entries) - kept)
+	if r.DroppedTimeEntries > 0 {
+		if err := s.timeIndex.truncate(kept); err != nil {
+			return err
+		}
+	}
+	s.maxTimestamp = 0
+	if kept > 0 {
+		for _, b := range batches {
+			s.maxTimestamp = max(s.maxTimestamp, b.ts)
+		}
+		return nil
+	}
+	for _, b := range batches {
+		if err := s.indexTime(b.ts, b.off, b.n); err != nil {
+			return err
+		}
+	}
+	r.RebuiltTimeEntries = uint64(len(s.timeIndex.entries))
+	return nil
+}
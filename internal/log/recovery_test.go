@@ -0,0 +1,134 @@
+package log
+
+import (
+	log_v1 "github.com/mishamolnar/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecovery(t *testing.T) {
+	for scenario, fn := range map[string]func(t *testing.T, dir string, c Config){
+		"torn store tail is truncated":       testRecoverTornTail,
+		"closed segments keep damaged tails": testRecoverClosedTail,
+		"unflushed store after crash":        testRecoverCrash,
+		"missing index is rebuilt":           testRecoverMissingIndex,
+		"clean shutdown reports no repairs":  testRecoverClean,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "recovery-test")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+			var c Config
+			c.Segment.MaxStoreBytes = 1024
+			c.Segment.MaxIndexBytes = 1024
+			fn(t, dir, c)
+		})
+	}
+}
+
+func appendRecords(t *testing.T, dir string, c Config, n int) {
+	t.Helper()
+	l, err := NewLog(dir, c)
+	require.NoError(t, err)
+	for i := 0; i < n; i++ {
+		_, err := l.Append(&log_v1.Record{Value: []byte("recover me"), Timestamp: 1})
+		require.NoError(t, err)
+	}
+	require.NoError(t, l.Close())
+}
+
+func testRecoverTornTail(t *testing.T, dir string, c Config) {
+	appendRecords(t, dir, c, 3)
+	f, err := os.OpenFile(filepath.Join(dir, "0.store"), os.O_RDWR|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 0, 0, 0, 0, 0, 42, 1, 2})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	l, err := NewLog(dir, c)
+	require.NoError(t, err)
+	require.Equal(t, []Recovery{{BaseOffset: 0, TruncatedBytes: 10}}, l.Recovered())
+	off, err := l.Append(&log_v1.Record{Value: []byte("after crash")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), off)
+	read, err := l.Read(off)
+	require.NoError(t, err)
+	require.Equal(t, []byte("after crash"), read.Value)
+}
+
+func testRecoverClosedTail(t *testing.T, dir string, c Config) {
+	c.Segment.MaxStoreBytes = 100
+	appendRecords(t, dir, c, 12)
+	l, err := NewLog(dir, c)
+	require.NoError(t, err)
+	require.Greater(t, len(l.segments), 3)
+	last := l.segments[1].baseOffset - 1
+	require.NoError(t, l.Close())
+
+	//a bit flipped in the last frame of a synced segment is corruption, not a torn write
+	store := filepath.Join(dir, "0.store")
+	b, err := os.ReadFile(store)
+	require.NoError(t, err)
+	b[len(b)-1] ^= 1
+	require.NoError(t, os.WriteFile(store, b, 0644))
+
+	l, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer l.Close()
+	require.Empty(t, l.Recovered())
+	_, err = l.Read(last)
+	require.Equal(t, log_v1.ErrCorruptRecord{Offset: last, Segment: 0}, err)
+	read, err := l.Read(last + 1)
+	require.NoError(t, err)
+	require.Equal(t, last+1, read.Offset)
+}
+
+func testRecoverCrash(t *testing.T, dir string, c Config) {
+	appendRecords(t, dir, c, 3)
+	//the last frame never fully reached the disk and the index was left at its mapped size
+	store := filepath.Join(dir, "0.store")
+	fi, err := os.Stat(store)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(store, fi.Size()-4))
+	require.NoError(t, os.Truncate(filepath.Join(dir, "0.index"), int64(c.Segment.MaxIndexBytes)))
+
+	l, err := NewLog(dir, c)
+	require.NoError(t, err)
+	rec := l.Recovered()
+	require.Len(t, rec, 1)
+	last := batchFrameSize(2, []byte("recover me"))
+	require.Equal(t, uint64(last-4), rec[0].TruncatedBytes)
+	require.Equal(t, c.Segment.MaxIndexBytes/entWidth-2, rec[0].DroppedEntries)
+
+	hi, err := l.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), hi)
+	_, err = l.Read(2)
+	require.Equal(t, log_v1.ErrOffsetOutOfRange{Offset: 2}, err)
+	off, err := l.Append(&log_v1.Record{Value: []byte("after crash")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), off)
+}
+
+func testRecoverMissingIndex(t *testing.T, dir string, c Config) {
+	appendRecords(t, dir, c, 3)
+	require.NoError(t, os.Remove(filepath.Join(dir, "0.index")))
+
+	l, err := NewLog(dir, c)
+	require.NoError(t, err)
+	require.Equal(t, []Recovery{{BaseOffset: 0, RebuiltEntries: 3}}, l.Recovered())
+	for i := uint64(0); i < 3; i++ {
+		read, err := l.Read(i)
+		require.NoError(t, err)
+		require.Equal(t, i, read.Offset)
+	}
+}
+
+func testRecoverClean(t *testing.T, dir string, c Config) {
+	appendRecords(t, dir, c, 3)
+	l, err := NewLog(dir, c)
+	require.NoError(t, err)
+	require.Empty(t, l.Recovered())
+}
@@ -0,0 +1,69 @@
+package log
+
+import "time"
+
+// SegmentInfo describes a segment of the log
+type SegmentInfo struct {
+	BaseOffset   uint64
+	NextOffset   uint64
+	Size         uint64
+	MaxTimestamp time.Time
+}
+
+func (s *segment) info() SegmentInfo {
+	return SegmentInfo{
+		BaseOffset:   s.baseOffset,
+		NextOffset:   s.nextOffset,
+		Size:         s.store.size,
+		MaxTimestamp: time.Unix(0, s.maxTimestamp),
+	}
+}
+
+// EnforceRetention deletes the oldest closed segments exceeding Config.Retention and returns what it deleted
+func (l *Log) EnforceRetention() ([]SegmentInfo, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r := l.Config.Retention
+	var total uint64
+	for _, s := range l.segments {
+		total += s.store.size
+	}
+	var deleted []SegmentInfo
+	for len(l.segments) > 1 { //the last segment is the active one
+		s := l.segments[0]
+		expired := r.MaxAge > 0 && l.now().Sub(time.Unix(0, s.maxTimestamp)) > r.MaxAge
+		oversized := r.MaxBytes > 0 && total > r.MaxBytes
+		if !expired && !oversized {
+			break
+		}
+		info := s.info()
+		if err := s.Remove(); err != nil {
+			return deleted, err
+		}
+		l.segments = l.segments[1:]
+		total -= info.Size
+		deleted = append(deleted, info)
+	}
+	return deleted, nil
+}
+
+// retainPeriodically is the background cleaner for Config.Retention
+func (l *Log) retainPeriodically(interval time.Duration) {
+	defer l.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			deleted, _ := l.EnforceRetention() //a segment that failed to go is retried on the next tick
+			if l.Config.Retention.OnDelete == nil {
+				continue
+			}
+			for _, info := range deleted {
+				l.Config.Retention.OnDelete(info)
+			}
+		}
+	}
+}
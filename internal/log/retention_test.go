@@ -0,0 +1,126 @@
+package log
+
+import (
+	log_v1 "github.com/mishamolnar/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRetention(t *testing.T) {
+	for scenario, fn := range map[string]func(t *testing.T, dir string){
+		"segments older than max age are deleted": testRetentionMaxAge,
+		"oldest segments go above max bytes":      testRetentionMaxBytes,
+		"background cleaner reports deletions":    testRetentionBackground,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "retention-test")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+			fn(t, dir)
+		})
+	}
+}
+
+// appendMinutely appends one record per minute starting at start, each filling a segment of its own
+func appendMinutely(t *testing.T, l *Log, start time.Time, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		_, err := l.Append(&log_v1.Record{
+			Value:     []byte("retained for a while"),
+			Timestamp: start.Add(time.Duration(i) * time.Minute).UnixNano(),
+		})
+		require.NoError(t, err)
+	}
+}
+
+func testRetentionMaxAge(t *testing.T, dir string) {
+	var c Config
+	c.Segment.MaxStoreBytes = 32
+	c.Retention.MaxAge = 10 * time.Minute
+	c.Retention.CheckInterval = time.Hour
+	l, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer l.Close()
+	start := time.Unix(1700000000, 0)
+	clock := start
+	l.now = func() time.Time { return clock }
+	appendMinutely(t, l, start, 5)
+	require.Len(t, l.segments, 6)
+
+	clock = start.Add(12*time.Minute + 30*time.Second)
+	deleted, err := l.EnforceRetention()
+	require.NoError(t, err)
+	require.Len(t, deleted, 3)
+	for i, info := range deleted {
+		require.Equal(t, uint64(i), info.BaseOffset)
+		require.Equal(t, start.Add(time.Duration(i)*time.Minute), info.MaxTimestamp)
+	}
+	lowest, err := l.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), lowest)
+	_, err = l.Read(2)
+	require.Equal(t, log_v1.ErrOffsetOutOfRange{Offset: 2}, err)
+
+	//the active segment stays no matter how old everything is
+	clock = start.Add(24 * time.Hour)
+	deleted, err = l.EnforceRetention()
+	require.NoError(t, err)
+	require.Len(t, deleted, 2)
+	require.Len(t, l.segments, 1)
+	_, err = l.Append(&log_v1.Record{Value: []byte("fresh")})
+	require.NoError(t, err)
+}
+
+func testRetentionMaxBytes(t *testing.T, dir string) {
+	var c Config
+	c.Segment.MaxStoreBytes = 32
+	c.Retention.CheckInterval = time.Hour
+	l, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer l.Close()
+	appendMinutely(t, l, time.Now(), 5)
+	size := l.segments[1].store.size
+	l.Config.Retention.MaxBytes = size * 2
+
+	deleted, err := l.EnforceRetention()
+	require.NoError(t, err)
+	require.Len(t, deleted, 3)
+	require.Equal(t, uint64(3), l.segments[0].baseOffset)
+	for _, info := range deleted {
+		require.NoFileExists(t, filepath.Join(l.Dir, storeName(info.BaseOffset)))
+	}
+
+	deleted, err = l.EnforceRetention()
+	require.NoError(t, err)
+	require.Empty(t, deleted)
+}
+
+func testRetentionBackground(t *testing.T, dir string) {
+	var c Config
+	c.Segment.MaxStoreBytes = 32
+	c.Retention.MaxBytes = 1
+	c.Retention.CheckInterval = 5 * time.Millisecond
+	deleted := make(chan SegmentInfo, 10)
+	c.Retention.OnDelete = func(info SegmentInfo) { deleted <- info }
+	l, err := NewLog(dir, c)
+	require.NoError(t, err)
+	appendMinutely(t, l, time.Now(), 2)
+
+	for _, want := range []uint64{0, 1} {
+		select {
+		case info := <-deleted:
+			require.Equal(t, want, info.BaseOffset)
+		case <-time.After(time.Second):
+			t.Fatal("segment was not deleted in time")
+		}
+	}
+	require.NoError(t, l.Close())
+}
+
+func storeName(base uint64) string {
+	return strconv.FormatUint(base, 10) + ".store"
+}
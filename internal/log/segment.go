@@ -1,11 +1,14 @@
 package log
 
 import (
+	"errors"
 	"fmt"
 	"github.com/mishamolnar/proglog/api/v1"
 	"google.golang.org/protobuf/proto"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -14,11 +17,21 @@ import (
 type segment struct {
 	store                  *store
 	index                  *index
+	timeIndex              *timeIndex
 	baseOffset, nextOffset uint64
+	maxTimestamp           int64
+	sinceTimeEntry         uint64 //store bytes appended since the last time index entry
 	config                 Config
+	recovery               Recovery
 }
 
 func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
+	return openSegment(dir, baseOffset, c, 0)
+}
+
+// openSegment opens a segment that may already hold records, end is where the segment after it starts when
+// there is one and the segment is closed
+func openSegment(dir string, baseOffset uint64, c Config, end uint64) (*segment, error) {
 	s := &segment{baseOffset: baseOffset, config: c}
 	storeFile, err := os.OpenFile(
 		strings.Join([]string{dir, fmt.Sprintf("%d%s", baseOffset, ".store")}, string(filepath.Separator)),
@@ -27,7 +40,7 @@ func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
 	if err != nil {
 		return nil, err
 	}
-	if s.store, err = newStore(storeFile); err != nil {
+	if s.store, err = newStore(storeFile, c); err != nil {
 		return nil, err
 	}
 	indexFile, err := os.OpenFile(
@@ -40,50 +53,257 @@ func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
 	if s.index, err = newIndex(indexFile, c); err != nil {
 		return nil, err
 	}
+	timeIndexFile, err := os.OpenFile(
+		strings.Join([]string{dir, fmt.Sprintf("%d%s", baseOffset, ".timeindex")}, string(filepath.Separator)),
+		os.O_RDWR|os.O_CREATE|os.O_APPEND,
+		0644)
+	if err != nil {
+		return nil, err
+	}
+	if s.timeIndex, err = newTimeIndex(timeIndexFile); err != nil {
+		return nil, err
+	}
 
-	//initializing offset by retrieving last record from index. If error returned then baseOffset
-	if off, _, err := s.index.Read(-1); err != nil {
-		s.nextOffset = baseOffset
-	} else {
-		s.nextOffset = baseOffset + uint64(off) + 1
+	//initializing offset (and repairing the files if needed) by scanning the batches in the store
+	if s.recovery, err = s.recover(end); err != nil {
+		return nil, err
 	}
 	return s, nil
 }
 
-func (s *segment) Append(record *log_v1.Record) (offset uint64, err error) {
+// Append stores the records as one batch and returns the offset of the first one
+func (s *segment) Append(records ...*log_v1.Record) (offset uint64, err error) {
 	curr := s.nextOffset
-	record.Offset = curr
-	bytes, err := proto.Marshal(record)
-	if err != nil {
-		return 0, err
+	for i, record := range records {
+		record.Offset = curr + uint64(i)
 	}
-	_, pos, err := s.store.Append(bytes)
+	bytes, err := proto.Marshal(&log_v1.RecordBatch{BaseOffset: curr, Records: records})
 	if err != nil {
 		return 0, err
 	}
-	err = s.index.Write(uint32(curr-s.baseOffset), pos) //writing relative offsent
-	if err != nil {
+	if err = s.write(curr, records, bytes); err != nil {
 		return 0, err
 	}
-	s.nextOffset++
 	return curr, nil
 }
 
+// appendBatch stores a batch whose records already carry their offsets, the way compaction rewrites them
+func (s *segment) appendBatch(batch *log_v1.RecordBatch) error {
+	bytes, err := proto.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	return s.write(batch.BaseOffset, batch.Records, bytes)
+}
+
+func (s *segment) write(base uint64, records []*log_v1.Record, bytes []byte) error {
+	n, pos, err := s.store.Append(bytes)
+	if err != nil {
+		return err
+	}
+	err = s.index.Write(uint32(base-s.baseOffset), pos) //writing relative offsent
+	if err != nil {
+		return err
+	}
+	if err = s.indexTime(maxTimestamp(records), uint32(base-s.baseOffset), n); err != nil {
+		return err
+	}
+	s.nextOffset = records[len(records)-1].Offset + 1
+	return nil
+}
+
+// indexTime adds an entry to the time index when the batch raises the newest timestamp of the segment and the
+// previous entry is at least TimeIndexIntervalBytes behind
+func (s *segment) indexTime(ts int64, off uint32, n uint64) error {
+	s.sinceTimeEntry += n
+	if ts > s.maxTimestamp {
+		s.maxTimestamp = ts
+	}
+	if s.maxTimestamp <= s.timeIndex.last() {
+		return nil
+	}
+	if len(s.timeIndex.entries) > 0 && s.sinceTimeEntry < s.config.Segment.TimeIndexIntervalBytes {
+		return nil
+	}
+	s.sinceTimeEntry = 0
+	return s.timeIndex.Write(s.maxTimestamp, off)
+}
+
+func maxTimestamp(records []*log_v1.Record) int64 {
+	var ts int64
+	for _, r := range records {
+		ts = max(ts, r.Timestamp)
+	}
+	return ts
+}
+
+// offsetForTime returns the offset of the first record in the segment whose timestamp is not older than ts
+func (s *segment) offsetForTime(ts int64) (uint64, bool, error) {
+	if s.index.size == 0 || s.maxTimestamp < ts { //nothing indexed, an empty or fully compacted segment
+		return 0, false, nil
+	}
+	_, pos, err := s.index.Search(s.timeIndex.Search(ts))
+	if err != nil {
+		return 0, false, err
+	}
+	for pos < s.store.size {
+		n, err := s.store.frameSize(pos)
+		if err != nil {
+			return 0, false, err
+		}
+		batch, err := s.readBatch(pos)
+		if err != nil && !errors.Is(err, errChecksum) { //a damaged batch cannot answer, the next one might
+			return 0, false, err
+		}
+		for _, r := range batch.GetRecords() {
+			if r.Timestamp >= ts {
+				return r.Offset, true, nil
+			}
+		}
+		pos += n
+	}
+	return 0, false, nil
+}
+
+// Read returns the record at off or, when compaction removed it, the next record of the segment.
+// io.EOF means nothing at or after off is left in the segment.
 func (s *segment) Read(off uint64) (*log_v1.Record, error) {
 	if off < s.baseOffset || off > s.nextOffset {
 		return nil, fmt.Errorf("offset is out of bounds [%d, %d)", s.baseOffset, s.nextOffset)
 	}
-	_, pos, err := s.index.Read(int64(off - s.baseOffset))
-	if err != nil {
+	_, pos, err := s.index.Search(uint32(off - s.baseOffset))
+	if err == io.EOF { //off lies before the first batch left by compaction
+		pos = 0
+	} else if err != nil {
 		return nil, err
 	}
+	for pos < s.store.size {
+		batch, err := s.readBatch(pos)
+		if errors.Is(err, errChecksum) {
+			return nil, log_v1.ErrCorruptRecord{Offset: off, Segment: s.baseOffset}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if i := off - batch.BaseOffset; i < uint64(len(batch.Records)) && batch.Records[i].Offset == off {
+			return batch.Records[i], nil
+		}
+		i := sort.Search(len(batch.Records), func(i int) bool {
+			return batch.Records[i].Offset >= off
+		})
+		if i < len(batch.Records) {
+			return batch.Records[i], nil
+		}
+		n, err := s.store.frameSize(pos)
+		if err != nil {
+			return nil, err
+		}
+		pos += n
+	}
+	return nil, io.EOF
+}
+
+// scan calls fn with the segment's records from off on, in order, until fn returns false
+func (s *segment) scan(off uint64, fn func(*log_v1.Record) bool) error {
+	_, pos, err := s.index.Search(uint32(off - s.baseOffset))
+	if err == io.EOF {
+		pos = 0
+	} else if err != nil {
+		return err
+	}
+	for pos < s.store.size {
+		n, err := s.store.frameSize(pos)
+		if err != nil {
+			return err
+		}
+		batch, err := s.readBatch(pos)
+		if errors.Is(err, errChecksum) {
+			return log_v1.ErrCorruptRecord{Offset: off, Segment: s.baseOffset}
+		}
+		if err != nil {
+			return err
+		}
+		for _, rec := range batch.Records {
+			if rec.Offset < off {
+				continue
+			}
+			if !fn(rec) {
+				return nil
+			}
+			off = rec.Offset + 1
+		}
+		pos += n
+	}
+	return nil
+}
+
+// truncateFrom removes the records from off on, rewriting the batch off falls in with the records before it
+func (s *segment) truncateFrom(off uint64) error {
+	if off >= s.nextOffset {
+		return nil
+	}
+	rel := uint32(off - s.baseOffset)
+	entries := int(s.index.size / entWidth)
+	var err error
+	n := sort.Search(entries, func(i int) bool {
+		o, _, e := s.index.Read(int64(i))
+		if e != nil {
+			err = e
+		}
+		return o > rel
+	}) - 1
+	if err != nil {
+		return err
+	}
+	var kept []*log_v1.Record
+	pos, base := uint64(0), uint32(0)
+	if n >= 0 {
+		if base, pos, err = s.index.Read(int64(n)); err != nil {
+			return err
+		}
+		batch, err := s.readBatch(pos)
+		if err != nil {
+			return err
+		}
+		for _, rec := range batch.Records {
+			if rec.Offset < off {
+				kept = append(kept, rec)
+			}
+		}
+	} else {
+		n = 0
+	}
+	if err = s.store.truncate(pos); err != nil {
+		return err
+	}
+	s.index.size = uint64(n) * entWidth
+	t := len(s.timeIndex.entries)
+	for t > 0 && s.timeIndex.entries[t-1].off >= base {
+		t--
+	}
+	if err = s.timeIndex.truncate(t); err != nil {
+		return err
+	}
+	s.sinceTimeEntry = 0
+	if len(kept) > 0 {
+		if err = s.appendBatch(&log_v1.RecordBatch{BaseOffset: kept[0].Offset, Records: kept}); err != nil {
+			return err
+		}
+	}
+	s.nextOffset = off
+	return nil
+}
+
+func (s *segment) readBatch(pos uint64) (*log_v1.RecordBatch, error) {
 	data, err := s.store.Read(pos)
 	if err != nil {
 		return nil, err
 	}
-	record := &log_v1.Record{}
-	err = proto.Unmarshal(data, record)
-	return record, err
+	batch := &log_v1.RecordBatch{}
+	if err = proto.Unmarshal(data, batch); err != nil {
+		return nil, err
+	}
+	return batch, nil
 }
 
 func (s *segment) IsMaxed() bool {
@@ -101,9 +321,22 @@ func (s *segment) Remove() error {
 	if err := os.Remove(s.store.Name()); err != nil {
 		return err
 	}
+	if err := os.Remove(s.timeIndex.Name()); err != nil {
+		return err
+	}
 	return nil
 }
 
+func (s *segment) Sync() error {
+	if err := s.store.Sync(); err != nil {
+		return err
+	}
+	if err := s.timeIndex.Sync(); err != nil {
+		return err
+	}
+	return s.index.Sync()
+}
+
 func (s *segment) Close() error {
 	if err := s.index.Close(); err != nil {
 		return err
@@ -111,5 +344,8 @@ func (s *segment) Close() error {
 	if err := s.store.Close(); err != nil {
 		return err
 	}
+	if err := s.timeIndex.Close(); err != nil {
+		return err
+	}
 	return nil
 }
@@ -0,0 +1,227 @@
+package log
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+)
+
+// A snapshot is a header describing the segments followed by the bytes of each segment's store, in order.
+// All integers are big-endian:
+//
+//	magic    [4]byte "PLSN"
+//	version  uint16  1
+//	segments uint32
+//	then per segment:
+//	  base offset uint64
+//	  next offset uint64  offset after the segment's last record
+//	  store size  uint64
+//	then per segment, store size bytes of checksummed store frames
+//
+// Indexes are not part of a snapshot, they are rebuilt from the store frames on restore.
+const (
+	snapshotVersion = 1
+	segmentHdrWidth = 24
+)
+
+var snapshotMagic = [4]byte{'P', 'L', 'S', 'N'}
+
+type snapshotSegment struct {
+	segment    *segment
+	baseOffset uint64
+	nextOffset uint64
+	size       uint64
+}
+
+// Snapshot writes the whole log to w in the snapshot format. It copies the log as it was when called, appends
+// go on while it is written
+func (l *Log) Snapshot(w io.Writer) error {
+	l.mu.RLock()
+	segments := l.snapshotSegments()
+	l.mu.RUnlock()
+	defer releaseSnapshot(segments)
+	return writeSnapshot(w, segments)
+}
+
+// snapshotSegments captures the segment boundaries a snapshot is taken at and pins their stores, so that they
+// can be copied without the lock even when segments are removed in the meantime. The caller holds l.mu and
+// releases the segments once written
+func (l *Log) snapshotSegments() []snapshotSegment {
+	segments := make([]snapshotSegment, len(l.segments))
+	for i, s := range l.segments {
+		s.store.pin()
+		segments[i] = snapshotSegment{segment: s, baseOffset: s.baseOffset, nextOffset: s.nextOffset, size: s.store.size}
+	}
+	return segments
+}
+
+func releaseSnapshot(segments []snapshotSegment) {
+	for _, s := range segments {
+		s.segment.store.unpin()
+	}
+}
+
+// writeSnapshot writes the segments as they were captured, records appended since are left out
+func writeSnapshot(w io.Writer, segments []snapshotSegment) error {
+	bw := bufio.NewWriter(w)
+	header := make([]byte, 0, 10+segmentHdrWidth*len(segments))
+	header = append(header, snapshotMagic[:]...)
+	header = enc.AppendUint16(header, snapshotVersion)
+	header = enc.AppendUint32(header, uint32(len(segments)))
+	for _, s := range segments {
+		header = enc.AppendUint64(header, s.baseOffset)
+		header = enc.AppendUint64(header, s.nextOffset)
+		header = enc.AppendUint64(header, s.size)
+	}
+	if _, err := bw.Write(header); err != nil {
+		return err
+	}
+	for _, s := range segments {
+		if _, err := io.Copy(bw, io.NewSectionReader(s.segment.store, 0, int64(s.size))); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Restore recreates in dir the log a snapshot read from r was taken of and opens it with c
+func Restore(dir string, r io.Reader, c Config) (*Log, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if path.Ext(f.Name()) == ".store" {
+			return nil, fmt.Errorf("restore into %s: directory already holds a log", dir)
+		}
+	}
+	br := bufio.NewReader(r)
+	segments, err := readSnapshotHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	for _, seg := range segments {
+		if err = restoreStore(dir, seg, br); err != nil {
+			removeRestored(dir)
+			return nil, err
+		}
+	}
+	l, err := NewLog(dir, c)
+	if err != nil {
+		removeRestored(dir)
+		return nil, err
+	}
+	if err = l.verifyRestore(segments); err != nil {
+		l.Close()
+		removeRestored(dir)
+		return nil, err
+	}
+	return l, nil
+}
+
+// removeRestored cleans up after a failed restore, the directory held no log before it
+func removeRestored(dir string) {
+	files, _ := os.ReadDir(dir)
+	for _, f := range files {
+		switch path.Ext(f.Name()) {
+		case ".store", ".index", ".timeindex":
+			os.Remove(path.Join(dir, f.Name()))
+		}
+	}
+}
+
+func readSnapshotHeader(r io.Reader) ([]snapshotSegment, error) {
+	fixed := make([]byte, 10)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, fmt.Errorf("snapshot header: %w", err)
+	}
+	if [4]byte(fixed[:4]) != snapshotMagic {
+		return nil, errors.New("not a log snapshot")
+	}
+	if v := enc.Uint16(fixed[4:]); v != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", v)
+	}
+	n := enc.Uint32(fixed[6:])
+	if n == 0 {
+		return nil, errors.New("snapshot has no segments")
+	}
+	//the count is not trusted to size the slice up front, a corrupt one fails on the entries it lacks
+	var segments []snapshotSegment
+	entry := make([]byte, segmentHdrWidth)
+	for i := 0; i < int(n); i++ {
+		if _, err := io.ReadFull(r, entry); err != nil {
+			return nil, fmt.Errorf("snapshot header: %w", err)
+		}
+		segments = append(segments, snapshotSegment{
+			baseOffset: enc.Uint64(entry),
+			nextOffset: enc.Uint64(entry[8:]),
+			size:       enc.Uint64(entry[16:]),
+		})
+		if i > 0 && segments[i].baseOffset < segments[i-1].nextOffset {
+			return nil, fmt.Errorf("snapshot segment %d overlaps the one before", segments[i].baseOffset)
+		}
+	}
+	return segments, nil
+}
+
+func restoreStore(dir string, seg snapshotSegment, r io.Reader) error {
+	f, err := os.OpenFile(path.Join(dir, fmt.Sprintf("%d.store", seg.baseOffset)), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err = io.CopyN(f, r, int64(seg.size)); err != nil {
+		return fmt.Errorf("snapshot segment %d: %w", seg.baseOffset, err)
+	}
+	return f.Sync()
+}
+
+// restore replaces the log's records with the snapshot read from r
+func (l *Log) restore(r io.Reader) error {
+	br := bufio.NewReader(r)
+	segments, err := readSnapshotHeader(br)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, s := range l.segments {
+		if err = s.Remove(); err != nil {
+			return err
+		}
+	}
+	l.segments, l.activeSegment = nil, nil
+	for _, seg := range segments {
+		if err = restoreStore(l.Dir, seg, br); err != nil {
+			return err
+		}
+	}
+	if err = l.setup(); err != nil {
+		return err
+	}
+	close(l.appended)
+	l.appended = make(chan struct{})
+	return l.verifyRestore(segments)
+}
+
+// verifyRestore checks the opened log against the snapshot header, recovery must not have had to cut anything
+func (l *Log) verifyRestore(segments []snapshotSegment) error {
+	if len(l.segments) != len(segments) {
+		return fmt.Errorf("restored %d segments of %d", len(l.segments), len(segments))
+	}
+	for _, r := range l.recovered {
+		if r.TruncatedBytes > 0 {
+			return fmt.Errorf("snapshot segment %d is corrupt", r.BaseOffset)
+		}
+	}
+	for i, s := range l.segments {
+		//compaction may have removed the last records of a closed segment, not of the active one
+		if s.nextOffset > segments[i].nextOffset || i == len(segments)-1 && s.nextOffset != segments[i].nextOffset {
+			return fmt.Errorf("snapshot segment %d ends at %d instead of %d", s.baseOffset, s.nextOffset, segments[i].nextOffset)
+		}
+	}
+	return nil
+}
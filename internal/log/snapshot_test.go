@@ -0,0 +1,165 @@
+package log
+
+import (
+	"bytes"
+	log_v1 "github.com/mishamolnar/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+	"io"
+	"math"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	for scenario, fn := range map[string]func(t *testing.T, l *Log, dir string){
+		"restore recreates the log":      testSnapshotRestore,
+		"restore keeps compaction gaps":  testSnapshotCompacted,
+		"damaged snapshots are rejected": testSnapshotDamaged,
+		"appends go on while writing":    testSnapshotConcurrent,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "snapshot-test")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+			restoreDir, err := os.MkdirTemp("", "restore-test")
+			require.NoError(t, err)
+			defer os.RemoveAll(restoreDir)
+			var c Config
+			c.Segment.MaxStoreBytes = 128
+			c.Segment.InitialOffset = 16
+			l, err := NewLog(dir, c)
+			require.NoError(t, err)
+			defer l.Close()
+			fn(t, l, restoreDir)
+		})
+	}
+}
+
+func testSnapshotRestore(t *testing.T, l *Log, dir string) {
+	for i := 0; i < 30; i++ {
+		_, err := l.Append(&log_v1.Record{Value: []byte{byte(i)}, Key: []byte("k"), Timestamp: int64(1000 + i)})
+		require.NoError(t, err)
+	}
+	var snapshot bytes.Buffer
+	require.NoError(t, l.Snapshot(&snapshot))
+
+	restored, err := Restore(dir, &snapshot, l.Config)
+	require.NoError(t, err)
+	defer restored.Close()
+	require.Len(t, restored.segments, len(l.segments))
+	lowest, err := restored.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(16), lowest)
+	for off := uint64(16); off < 46; off++ {
+		want, err := l.Read(off)
+		require.NoError(t, err)
+		got, err := restored.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, want.Value, got.Value)
+		require.Equal(t, want.Timestamp, got.Timestamp)
+	}
+	//the indexes were rebuilt too
+	off, err := restored.OffsetForTime(time.Unix(0, 1010))
+	require.NoError(t, err)
+	require.Equal(t, uint64(26), off)
+
+	//and the restored log carries on where the original stopped
+	off, err = restored.Append(&log_v1.Record{Value: []byte("next")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(46), off)
+
+	_, err = Restore(dir, &snapshot, l.Config)
+	require.Error(t, err, "restoring over a log")
+}
+
+func testSnapshotConcurrent(t *testing.T, l *Log, dir string) {
+	for i := 0; i < 30; i++ {
+		_, err := l.Append(&log_v1.Record{Value: []byte{byte(i)}})
+		require.NoError(t, err)
+	}
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(l.Snapshot(w))
+	}()
+	time.Sleep(10 * time.Millisecond) //the snapshot is blocked writing to the pipe
+
+	appended := make(chan error)
+	go func() {
+		_, err := l.Append(&log_v1.Record{Value: []byte("during")})
+		appended <- err
+	}()
+	select {
+	case err := <-appended:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("append waited for the snapshot")
+	}
+	//segments removed meanwhile are still copied
+	require.NoError(t, l.Truncate(40))
+
+	restored, err := Restore(dir, r, l.Config)
+	require.NoError(t, err)
+	defer restored.Close()
+	lowest, err := restored.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(16), lowest)
+	next, err := restored.NextOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(46), next)
+	for off := uint64(16); off < 46; off++ {
+		got, err := restored.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, []byte{byte(off - 16)}, got.Value)
+	}
+}
+
+func testSnapshotCompacted(t *testing.T, l *Log, dir string) {
+	for i := 0; i < 30; i++ {
+		_, err := l.Append(&log_v1.Record{Value: []byte{byte(i)}, Key: []byte{byte(i % 3)}})
+		require.NoError(t, err)
+	}
+	_, err := l.Compact()
+	require.NoError(t, err)
+	var snapshot bytes.Buffer
+	require.NoError(t, l.Snapshot(&snapshot))
+
+	restored, err := Restore(dir, &snapshot, l.Config)
+	require.NoError(t, err)
+	defer restored.Close()
+	it, restoredIt := l.NewIterator(0), restored.NewIterator(0)
+	for it.Next() {
+		require.True(t, restoredIt.Next())
+		require.Equal(t, it.Record().Offset, restoredIt.Record().Offset)
+	}
+	require.False(t, restoredIt.Next())
+}
+
+func testSnapshotDamaged(t *testing.T, l *Log, dir string) {
+	for i := 0; i < 10; i++ {
+		_, err := l.Append(&log_v1.Record{Value: []byte("some record")})
+		require.NoError(t, err)
+	}
+	var snapshot bytes.Buffer
+	require.NoError(t, l.Snapshot(&snapshot))
+	b := snapshot.Bytes()
+
+	_, err := Restore(dir, bytes.NewReader(b[:len(b)-5]), l.Config)
+	require.Error(t, err)
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, files, "a failed restore leaves nothing behind")
+
+	_, err = Restore(dir, bytes.NewReader(append([]byte("XXXX"), b[4:]...)), l.Config)
+	require.EqualError(t, err, "not a log snapshot")
+
+	//a huge segment count fails on the missing entries instead of allocating for all of them
+	huge := append([]byte(nil), b[:6]...)
+	huge = enc.AppendUint32(huge, math.MaxUint32)
+	_, err = Restore(dir, bytes.NewReader(huge), l.Config)
+	require.ErrorIs(t, err, io.EOF)
+
+	none := enc.AppendUint32(append([]byte(nil), b[:6]...), 0)
+	_, err = Restore(dir, bytes.NewReader(none), l.Config)
+	require.EqualError(t, err, "snapshot has no segments")
+}
@@ -3,69 +3,167 @@ package log
 import (
 	"bufio"
 	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
 	"os"
 	"sync"
 )
 
 var (
-	enc = binary.BigEndian
+	enc      = binary.BigEndian
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+	errChecksum = errors.New("store: record checksum mismatch")
 )
 
+// each frame in the store is [payload length][crc32 of codec and payload][codec][payload]
 const (
-	lenWidth = 8
+	lenWidth         = 8
+	crcWidth         = 4
+	codecWidth       = 1
+	frameHeaderWidth = lenWidth + crcWidth + codecWidth
 )
 
 type store struct {
 	*os.File
-	mu   sync.Mutex
-	buf  *bufio.Writer
-	size uint64
+	mu    sync.Mutex
+	buf   *bufio.Writer
+	size  uint64
+	codec Codec
+	// pins keep the file open for readers that do not hold the log lock, Close leaves it to the last unpin
+	pins   int
+	closed bool
 }
 
-func newStore(f *os.File) (*store, error) {
+func newStore(f *os.File, config Config) (*store, error) {
 	file, err := os.Stat(f.Name())
 	if err != nil {
 		return nil, err
 	}
 	size := uint64(file.Size())
 	return &store{
-		File: f,
-		buf:  bufio.NewWriter(f),
-		size: size,
+		File:  f,
+		buf:   bufio.NewWriter(f),
+		size:  size,
+		codec: config.Segment.Compression,
 	}, nil
 }
 
 func (s *store) Append(p []byte) (n uint64, pos uint64, err error) {
+	p, codec, err := s.codec.compress(p)
+	if err != nil {
+		return 0, 0, err
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if s.closed {
+		return 0, 0, ErrClosed
+	}
 	pos = s.size
-	if err := binary.Write(s.buf, enc, uint64(len(p))); err != nil {
+	header := make([]byte, frameHeaderWidth)
+	enc.PutUint64(header[:lenWidth], uint64(len(p)))
+	header[lenWidth+crcWidth] = byte(codec)
+	enc.PutUint32(header[lenWidth:], checksum(header, p))
+	if _, err := s.buf.Write(header); err != nil {
 		return 0, 0, err
 	}
 	w, err := s.buf.Write(p)
 	if err != nil {
 		return 0, 0, err
 	}
-	w += lenWidth
+	w += frameHeaderWidth
 	s.size += uint64(w)
 	return uint64(w), pos, nil
 }
 
+// Read returns the decompressed payload of the frame at pos, or errChecksum if it does not match its checksum
 func (s *store) Read(pos uint64) ([]byte, error) {
+	frame, err := s.frame(pos)
+	if err != nil {
+		return nil, err
+	}
+	return Codec(frame[lenWidth+crcWidth]).decompress(frame[frameHeaderWidth:])
+}
+
+func checksum(header, payload []byte) uint32 {
+	return crc32.Update(crc32.Checksum(header[lenWidth+crcWidth:frameHeaderWidth], crcTable), crcTable, payload)
+}
+
+// frame returns the whole verified frame (header included) starting at pos
+func (s *store) frame(pos uint64) ([]byte, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if err := s.buf.Flush(); err != nil {
 		return nil, err
 	}
-	size := make([]byte, lenWidth)
-	if _, err := s.File.ReadAt(size, int64(pos)); err != nil {
+	if pos >= s.size {
+		return nil, io.EOF
+	}
+	if pos+frameHeaderWidth > s.size {
+		return nil, errChecksum
+	}
+	header := make([]byte, frameHeaderWidth)
+	if _, err := s.File.ReadAt(header, int64(pos)); err != nil {
 		return nil, err
 	}
-	b := make([]byte, enc.Uint64(size))
-	if _, err := s.File.ReadAt(b, int64(pos+lenWidth)); err != nil {
+	size := enc.Uint64(header[:lenWidth])
+	if size > s.size-pos-frameHeaderWidth { //length prefix itself is damaged
+		return nil, errChecksum
+	}
+	frame := make([]byte, frameHeaderWidth+size)
+	copy(frame, header)
+	if _, err := s.File.ReadAt(frame[frameHeaderWidth:], int64(pos+frameHeaderWidth)); err != nil {
 		return nil, err
 	}
-	return b, nil
+	if checksum(header, frame[frameHeaderWidth:]) != enc.Uint32(header[lenWidth:]) {
+		return nil, errChecksum
+	}
+	return frame, nil
+}
+
+// frameSize returns the length of the frame at pos as recorded in its header, without verifying the payload
+func (s *store) frameSize(pos uint64) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.buf.Flush(); err != nil {
+		return 0, err
+	}
+	if pos+frameHeaderWidth > s.size {
+		return 0, errChecksum
+	}
+	size := make([]byte, lenWidth)
+	if _, err := s.File.ReadAt(size, int64(pos)); err != nil {
+		return 0, err
+	}
+	n := enc.Uint64(size)
+	if n > s.size-pos-frameHeaderWidth {
+		return 0, errChecksum
+	}
+	return frameHeaderWidth + n, nil
+}
+
+// Sync flushes the buffered frames and commits the file to stable storage
+func (s *store) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	return s.File.Sync()
+}
+
+func (s *store) truncate(size uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	if err := s.File.Truncate(int64(size)); err != nil {
+		return err
+	}
+	s.size = size
+	return nil
 }
 
 func (s *store) ReadAt(p []byte, off int64) (int, error) {
@@ -83,5 +181,26 @@ func (s *store) Close() error {
 	if err := s.buf.Flush(); err != nil {
 		return err
 	}
+	s.closed = true
+	if s.pins > 0 {
+		return nil
+	}
 	return s.File.Close()
 }
+
+// pin keeps the store readable until unpin, even once it has been closed or its segment removed
+func (s *store) pin() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pins++
+}
+
+func (s *store) unpin() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pins--
+	if s.pins == 0 && s.closed {
+		return s.File.Close()
+	}
+	return nil
+}
@@ -8,21 +8,21 @@ import (
 
 var (
 	write = []byte("some log")
-	width = uint64(len(write) + lenWidth)
+	width = uint64(len(write) + frameHeaderWidth)
 )
 
 func TestStoreAppendRead(t *testing.T) {
 	f, err := os.CreateTemp("", "store_append_read_test")
 	require.NoError(t, err)
 	defer os.ReadFile(f.Name())
-	s, err := newStore(f)
+	s, err := newStore(f, Config{})
 	require.NoError(t, err)
 
 	testAppend(t, s)
 	testRead(t, s)
 	testReadAt(t, s)
 
-	s, err = newStore(f)
+	s, err = newStore(f, Config{})
 	require.NoError(t, err)
 	testRead(t, s)
 }
@@ -48,13 +48,13 @@ func testRead(t *testing.T, s *store) {
 func testReadAt(t *testing.T, s *store) {
 	t.Helper()
 	for i, off := uint64(1), int64(0); i < 4; i++ {
-		bytes := make([]byte, lenWidth)
+		bytes := make([]byte, frameHeaderWidth)
 		n, err := s.ReadAt(bytes, off)
 		require.NoError(t, err)
-		require.Equal(t, lenWidth, n)
+		require.Equal(t, frameHeaderWidth, n)
 		off += int64(n)
 
-		size := enc.Uint64(bytes)
+		size := enc.Uint64(bytes[:lenWidth])
 		bytes = make([]byte, size)
 		n, err = s.ReadAt(bytes, off)
 		require.NoError(t, err)
@@ -64,11 +64,38 @@ func testReadAt(t *testing.T, s *store) {
 	}
 }
 
+func TestStoreChecksum(t *testing.T) {
+	f, err := os.CreateTemp("", "store_checksum_test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	s, err := newStore(f, Config{})
+	require.NoError(t, err)
+	testAppend(t, s)
+
+	//flip a single bit in the payload of the second record
+	b := make([]byte, 1)
+	_, err = s.ReadAt(b, int64(width+frameHeaderWidth))
+	require.NoError(t, err)
+	b[0] ^= 0x01
+	f2, err := os.OpenFile(f.Name(), os.O_RDWR, 0644)
+	require.NoError(t, err)
+	_, err = f2.WriteAt(b, int64(width+frameHeaderWidth))
+	require.NoError(t, err)
+	require.NoError(t, f2.Close())
+
+	_, err = s.Read(0)
+	require.NoError(t, err)
+	_, err = s.Read(width)
+	require.Equal(t, errChecksum, err)
+	_, err = s.Read(width * 2)
+	require.NoError(t, err)
+}
+
 func TestStoreClose(t *testing.T) {
 	f, err := os.CreateTemp("", "store_close_test")
 	require.NoError(t, err)
 	defer os.ReadFile(f.Name())
-	s, err := newStore(f)
+	s, err := newStore(f, Config{})
 	require.NoError(t, err)
 	_, _, err = s.Append(write)
 	require.NoError(t, err)
@@ -0,0 +1,98 @@
+package log
+
+import (
+	"os"
+	"sort"
+)
+
+var (
+	tsWidth      uint64 = 8
+	timeEntWidth        = tsWidth + offWidth
+)
+
+// timeEntry says that no record up to and including the batch at relative offset off is newer than ts
+type timeEntry struct {
+	ts  int64
+	off uint32
+}
+
+// timeIndex is the sparse .timeindex of a segment. It is small enough to be kept in memory, the file is only
+// appended to and read back on startup.
+type timeIndex struct {
+	file    *os.File
+	entries []timeEntry
+}
+
+func newTimeIndex(f *os.File) (*timeIndex, error) {
+	fi, err := os.Stat(f.Name())
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, uint64(fi.Size())/timeEntWidth*timeEntWidth) //a torn last entry is dropped
+	if _, err = f.ReadAt(b, 0); err != nil && len(b) > 0 {
+		return nil, err
+	}
+	t := &timeIndex{file: f}
+	for p := uint64(0); p < uint64(len(b)); p += timeEntWidth {
+		t.entries = append(t.entries, timeEntry{
+			ts:  int64(enc.Uint64(b[p : p+tsWidth])),
+			off: enc.Uint32(b[p+tsWidth : p+timeEntWidth]),
+		})
+	}
+	return t, nil
+}
+
+func (t *timeIndex) Write(ts int64, off uint32) error {
+	b := make([]byte, timeEntWidth)
+	enc.PutUint64(b[:tsWidth], uint64(ts))
+	enc.PutUint32(b[tsWidth:], off)
+	if _, err := t.file.Write(b); err != nil {
+		return err
+	}
+	t.entries = append(t.entries, timeEntry{ts: ts, off: off})
+	return nil
+}
+
+// Search returns the relative offset of the batch to start scanning from when looking for the first record not
+// older than ts
+func (t *timeIndex) Search(ts int64) uint32 {
+	i := sort.Search(len(t.entries), func(i int) bool {
+		return t.entries[i].ts >= ts
+	})
+	if i == 0 {
+		return 0
+	}
+	return t.entries[i-1].off
+}
+
+// last returns the newest timestamp indexed so far
+func (t *timeIndex) last() int64 {
+	if len(t.entries) == 0 {
+		return 0
+	}
+	return t.entries[len(t.entries)-1].ts
+}
+
+// truncate keeps the first n entries
+func (t *timeIndex) truncate(n int) error {
+	if err := t.file.Truncate(int64(uint64(n) * timeEntWidth)); err != nil {
+		return err
+	}
+	t.entries = t.entries[:n]
+	return nil
+}
+
+func (t *timeIndex) Sync() error {
+	return t.file.Sync()
+}
+
+func (t *timeIndex) Close() error {
+	if err := t.file.Sync(); err != nil {
+		return err
+	}
+	return t.file.Close()
+}
+
+func (t *timeIndex) Name() string {
+	return t.file.Name()
+}
@@ -0,0 +1,43 @@
+package log
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+)
+
+func TestTimeIndex(t *testing.T) {
+	f, err := os.CreateTemp("", "timeindex_test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	idx, err := newTimeIndex(f)
+	require.NoError(t, err)
+	require.Equal(t, uint32(0), idx.Search(100))
+	require.Equal(t, int64(0), idx.last())
+
+	entries := []timeEntry{{ts: 100, off: 0}, {ts: 200, off: 5}, {ts: 300, off: 9}}
+	for _, e := range entries {
+		require.NoError(t, idx.Write(e.ts, e.off))
+	}
+	for ts, want := range map[int64]uint32{50: 0, 100: 0, 150: 0, 200: 0, 201: 5, 300: 5, 301: 9} {
+		require.Equal(t, want, idx.Search(ts), "search %d", ts)
+	}
+
+	//index should build its state from the existing file, ignoring a torn last entry
+	_, err = f.Write([]byte{1, 2, 3})
+	require.NoError(t, err)
+	require.NoError(t, idx.Close())
+	f, err = os.OpenFile(f.Name(), os.O_RDWR|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	idx, err = newTimeIndex(f)
+	require.NoError(t, err)
+	require.Equal(t, entries, idx.entries)
+	require.Equal(t, int64(300), idx.last())
+
+	require.NoError(t, idx.truncate(1))
+	require.Equal(t, entries[:1], idx.entries)
+	fi, err := os.Stat(f.Name())
+	require.NoError(t, err)
+	require.Equal(t, int64(timeEntWidth), fi.Size())
+}
@@ -0,0 +1,244 @@
+package log
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	log_v1 "github.com/mishamolnar/proglog/api/v1"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const topicConfigFile = "topic.json"
+
+var topicName = regexp.MustCompile(`^[a-zA-Z0-9_-][a-zA-Z0-9._-]*$`)
+
+// TopicConfig overrides parts of the manager's Config for a single topic, zero values keep the manager's
+type TopicConfig struct {
+	MaxStoreBytes     uint64        `json:"max_store_bytes,omitempty"`
+	MaxIndexBytes     uint64        `json:"max_index_bytes,omitempty"`
+	RetentionMaxAge   time.Duration `json:"retention_max_age,omitempty"`
+	RetentionMaxBytes uint64        `json:"retention_max_bytes,omitempty"`
+	// Partitions is fixed when the topic is created, zero means one
+	Partitions uint32 `json:"partitions,omitempty"`
+}
+
+func (tc TopicConfig) partitions() uint32 {
+	if tc.Partitions == 0 {
+		return 1
+	}
+	return tc.Partitions
+}
+
+func (tc TopicConfig) apply(c Config) Config {
+	if tc.MaxStoreBytes != 0 {
+		c.Segment.MaxStoreBytes = tc.MaxStoreBytes
+	}
+	if tc.MaxIndexBytes != 0 {
+		c.Segment.MaxIndexBytes = tc.MaxIndexBytes
+	}
+	if tc.RetentionMaxAge != 0 {
+		c.Retention.MaxAge = tc.RetentionMaxAge
+	}
+	if tc.RetentionMaxBytes != 0 {
+		c.Retention.MaxBytes = tc.RetentionMaxBytes
+	}
+	return c
+}
+
+type TopicInfo struct {
+	Name   string
+	Config TopicConfig
+}
+
+// Topic is a named stream split into independent partitions, each its own Log in a numbered subdirectory
+type Topic struct {
+	Name       string
+	Config     TopicConfig
+	partitions []*Log
+}
+
+func (t *Topic) Partitions() int {
+	return len(t.partitions)
+}
+
+func (t *Topic) Partition(p uint32) (*Log, error) {
+	if int(p) >= len(t.partitions) {
+		return nil, log_v1.ErrPartitionNotFound{Topic: t.Name, Partition: p}
+	}
+	return t.partitions[p], nil
+}
+
+func (t *Topic) close() error {
+	for _, l := range t.partitions {
+		if err := l.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TopicManager owns the named topics, each in its own directory under Dir next to the topic's config
+type TopicManager struct {
+	mu     sync.RWMutex
+	Dir    string
+	Config Config
+	topics map[string]*Topic
+}
+
+func NewTopicManager(dir string, c Config) (*TopicManager, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	m := &TopicManager{Dir: dir, Config: c, topics: make(map[string]*Topic)}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if !e.IsDir() || !topicName.MatchString(e.Name()) {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(dir, e.Name(), topicConfigFile))
+		if errors.Is(err, os.ErrNotExist) { //not a topic, or one whose creation never finished
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var tc TopicConfig
+		if err = json.Unmarshal(b, &tc); err != nil {
+			return nil, fmt.Errorf("topic %s: %w", e.Name(), err)
+		}
+		if err = m.open(e.Name(), tc); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *TopicManager) open(name string, tc TopicConfig) error {
+	if err := migrateUnpartitioned(filepath.Join(m.Dir, name)); err != nil {
+		return fmt.Errorf("topic %s: %w", name, err)
+	}
+	t := &Topic{Name: name, Config: tc}
+	for p := uint32(0); p < tc.partitions(); p++ {
+		dir := filepath.Join(m.Dir, name, strconv.FormatUint(uint64(p), 10))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.close()
+			return err
+		}
+		l, err := NewLog(dir, tc.apply(m.Config))
+		if err != nil {
+			t.close()
+			return err
+		}
+		t.partitions = append(t.partitions, l)
+	}
+	m.topics[name] = t
+	return nil
+}
+
+// migrateUnpartitioned moves the segments of a topic written before partitioning, which kept them next to
+// its config, into partition 0
+func migrateUnpartitioned(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		switch path.Ext(e.Name()) {
+		case ".store", ".index", ".timeindex":
+		default:
+			continue
+		}
+		if err = os.MkdirAll(filepath.Join(dir, "0"), 0755); err != nil {
+			return err
+		}
+		if err = os.Rename(filepath.Join(dir, e.Name()), filepath.Join(dir, "0", e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *TopicManager) CreateTopic(name string, tc TopicConfig) error {
+	if !topicName.MatchString(name) {
+		return log_v1.ErrInvalidTopic{Name: name}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.topics[name]; ok {
+		return log_v1.ErrTopicExists{Name: name}
+	}
+	dir := filepath.Join(m.Dir, name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	b, err := json.Marshal(tc)
+	if err != nil {
+		return err
+	}
+	//the config is written last: a directory without it is not a topic yet
+	if err = os.WriteFile(filepath.Join(dir, topicConfigFile), b, 0644); err != nil {
+		return err
+	}
+	return m.open(name, tc)
+}
+
+// DeleteTopic removes the topic together with all of its records
+func (m *TopicManager) DeleteTopic(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.topics[name]
+	if !ok {
+		return log_v1.ErrTopicNotFound{Name: name}
+	}
+	delete(m.topics, name)
+	for _, l := range t.partitions {
+		if err := l.Remove(); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(filepath.Join(m.Dir, name))
+}
+
+func (m *TopicManager) ListTopics() []TopicInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	topics := make([]TopicInfo, 0, len(m.topics))
+	for name, t := range m.topics {
+		topics = append(topics, TopicInfo{Name: name, Config: t.Config})
+	}
+	sort.Slice(topics, func(i, j int) bool {
+		return topics[i].Name < topics[j].Name
+	})
+	return topics
+}
+
+func (m *TopicManager) Topic(name string) (*Topic, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	t, ok := m.topics[name]
+	if !ok {
+		return nil, log_v1.ErrTopicNotFound{Name: name}
+	}
+	return t, nil
+}
+
+func (m *TopicManager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, t := range m.topics {
+		if err := t.close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package log
+
+import (
+	"context"
+	log_v1 "github.com/mishamolnar/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTopicManager(t *testing.T) {
+	for scenario, fn := range map[string]func(t *testing.T, dir string){
+		"create, list and write to topics":        testTopicCreate,
+		"invalid and duplicate topics fail":       testTopicCreateFails,
+		"topics and their configs survive reopen": testTopicReopen,
+		"delete removes the topic's records":      testTopicDelete,
+		"partitions are independent logs":         testTopicPartitions,
+		"unpartitioned topics become partition 0": testTopicUnpartitioned,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "topic-test")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+			fn(t, dir)
+		})
+	}
+}
+
+func testTopicCreate(t *testing.T, dir string) {
+	m, err := NewTopicManager(dir, Config{})
+	require.NoError(t, err)
+	defer m.Close()
+	require.NoError(t, m.CreateTopic("orders", TopicConfig{}))
+	require.NoError(t, m.CreateTopic("audit", TopicConfig{RetentionMaxAge: time.Hour}))
+	require.Equal(t, []TopicInfo{
+		{Name: "audit", Config: TopicConfig{RetentionMaxAge: time.Hour}},
+		{Name: "orders"},
+	}, m.ListTopics())
+
+	orders, err := partition(t, m, "orders", 0)
+	require.NoError(t, err)
+	audit, err := partition(t, m, "audit", 0)
+	require.NoError(t, err)
+	require.Equal(t, time.Hour, audit.Config.Retention.MaxAge)
+
+	//offsets are per topic
+	for _, l := range []*Log{orders, audit} {
+		off, err := l.Append(&log_v1.Record{Value: []byte("first")})
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), off)
+	}
+
+	_, err = partition(t, m, "missing", 0)
+	require.Equal(t, log_v1.ErrTopicNotFound{Name: "missing"}, err)
+}
+
+func testTopicCreateFails(t *testing.T, dir string) {
+	m, err := NewTopicManager(dir, Config{})
+	require.NoError(t, err)
+	defer m.Close()
+	for _, name := range []string{"", ".", "..", ".hidden", "a/b", "spa ce"} {
+		require.Equal(t, log_v1.ErrInvalidTopic{Name: name}, m.CreateTopic(name, TopicConfig{}))
+	}
+	require.NoError(t, m.CreateTopic("orders", TopicConfig{}))
+	require.Equal(t, log_v1.ErrTopicExists{Name: "orders"}, m.CreateTopic("orders", TopicConfig{}))
+}
+
+func testTopicReopen(t *testing.T, dir string) {
+	m, err := NewTopicManager(dir, Config{})
+	require.NoError(t, err)
+	tc := TopicConfig{MaxStoreBytes: 4096, RetentionMaxBytes: 1 << 20}
+	require.NoError(t, m.CreateTopic("orders", tc))
+	l, err := partition(t, m, "orders", 0)
+	require.NoError(t, err)
+	_, err = l.Append(&log_v1.Record{Value: []byte("kept")})
+	require.NoError(t, err)
+	require.NoError(t, m.Close())
+
+	//a directory without a config is not a topic
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "half-created"), 0755))
+
+	m, err = NewTopicManager(dir, Config{})
+	require.NoError(t, err)
+	defer m.Close()
+	require.Equal(t, []TopicInfo{{Name: "orders", Config: tc}}, m.ListTopics())
+	l, err = partition(t, m, "orders", 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4096), l.Config.Segment.MaxStoreBytes)
+	read, err := l.Read(0)
+	require.NoError(t, err)
+	require.Equal(t, []byte("kept"), read.Value)
+}
+
+func testTopicUnpartitioned(t *testing.T, dir string) {
+	//topics used to keep their segments next to their config
+	topicDir := filepath.Join(dir, "orders")
+	require.NoError(t, os.Mkdir(topicDir, 0755))
+	l, err := NewLog(topicDir, Config{})
+	require.NoError(t, err)
+	_, err = l.Append(&log_v1.Record{Value: []byte("kept")})
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+	require.NoError(t, os.WriteFile(filepath.Join(topicDir, topicConfigFile), []byte("{}"), 0644))
+
+	m, err := NewTopicManager(dir, Config{})
+	require.NoError(t, err)
+	defer m.Close()
+	l, err = partition(t, m, "orders", 0)
+	require.NoError(t, err)
+	read, err := l.Read(0)
+	require.NoError(t, err)
+	require.Equal(t, []byte("kept"), read.Value)
+	off, err := l.Append(&log_v1.Record{Value: []byte("next")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), off)
+	_, err = os.Stat(filepath.Join(topicDir, "0.store"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func testTopicDelete(t *testing.T, dir string) {
+	m, err := NewTopicManager(dir, Config{})
+	require.NoError(t, err)
+	defer m.Close()
+	require.NoError(t, m.CreateTopic("orders", TopicConfig{}))
+	//requests still holding a partition are failed rather than served from deleted files
+	l, err := partition(t, m, "orders", 0)
+	require.NoError(t, err)
+	waited := make(chan error, 1)
+	go func() {
+		waited <- l.Wait(context.Background(), 0)
+	}()
+	require.NoError(t, m.DeleteTopic("orders"))
+	require.ErrorIs(t, <-waited, ErrClosed)
+	_, err = l.Append(&log_v1.Record{Value: []byte("late")})
+	require.ErrorIs(t, err, ErrClosed)
+	_, err = os.Stat(filepath.Join(dir, "orders"))
+	require.True(t, os.IsNotExist(err))
+	require.Empty(t, m.ListTopics())
+	require.Equal(t, log_v1.ErrTopicNotFound{Name: "orders"}, m.DeleteTopic("orders"))
+
+	//the name can be reused and starts from scratch
+	require.NoError(t, m.CreateTopic("orders", TopicConfig{}))
+	l, err = partition(t, m, "orders", 0)
+	require.NoError(t, err)
+	_, err = l.Read(0)
+	require.Error(t, err)
+}
+
+func testTopicPartitions(t *testing.T, dir string) {
+	m, err := NewTopicManager(dir, Config{})
+	require.NoError(t, err)
+	require.NoError(t, m.CreateTopic("orders", TopicConfig{Partitions: 3}))
+	topic, err := m.Topic("orders")
+	require.NoError(t, err)
+	require.Equal(t, 3, topic.Partitions())
+	_, err = topic.Partition(3)
+	require.Equal(t, log_v1.ErrPartitionNotFound{Topic: "orders", Partition: 3}, err)
+
+	//every partition counts its offsets from zero
+	for p := uint32(0); p < 3; p++ {
+		l, err := topic.Partition(p)
+		require.NoError(t, err)
+		off, err := l.Append(&log_v1.Record{Value: []byte{byte(p)}})
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), off)
+	}
+	require.NoError(t, m.Close())
+
+	m, err = NewTopicManager(dir, Config{})
+	require.NoError(t, err)
+	defer m.Close()
+	for p := uint32(0); p < 3; p++ {
+		l, err := partition(t, m, "orders", p)
+		require.NoError(t, err)
+		read, err := l.Read(0)
+		require.NoError(t, err)
+		require.Equal(t, []byte{byte(p)}, read.Value)
+	}
+}
+
+func partition(t *testing.T, m *TopicManager, name string, p uint32) (*Log, error) {
+	t.Helper()
+	topic, err := m.Topic(name)
+	if err != nil {
+		return nil, err
+	}
+	return topic.Partition(p)
+}
@@ -0,0 +1,126 @@
+package mirror
+
+import (
+	"context"
+	log_v1 "github.com/mishamolnar/proglog/api/v1"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+// Progress is where a mirror stands against its source
+type Progress struct {
+	// SourceNext is the source's next offset when it was last asked
+	SourceNext uint64
+	// MirroredNext is the next offset the destination expects
+	MirroredNext uint64
+}
+
+// Lag is how many offsets the destination is behind the source
+func (p Progress) Lag() uint64 {
+	if p.SourceNext < p.MirroredNext {
+		return 0
+	}
+	return p.SourceNext - p.MirroredNext
+}
+
+// Mirror copies a partition from Source to Destination keeping every record's offset, gaps compaction left in
+// the source included. It continues from where the destination ends, so a mirror that stopped is resumed by
+// running it again. The destination has to allow AppendAt and must not be written to otherwise
+type Mirror struct {
+	Source      log_v1.LogClient
+	Destination log_v1.LogClient
+	Topic       string
+	Partition   uint32
+	// BatchSize caps the records sent with one AppendAt, 100 when zero
+	BatchSize int
+	// ReportInterval is how often Report is called, every second when zero
+	ReportInterval time.Duration
+	Report         func(Progress)
+}
+
+// Run mirrors until ctx is done or either side fails
+func (m *Mirror) Run(ctx context.Context) error {
+	offsets, err := m.Destination.GetOffsets(ctx, &log_v1.GetOffsetsRequest{Topic: m.Topic, Partition: m.Partition})
+	if err != nil {
+		return err
+	}
+	next := offsets.NextOffset
+	if offsets.LowestOffset == next { //an empty destination starts where the source does
+		source, err := m.Source.GetOffsets(ctx, &log_v1.GetOffsetsRequest{Topic: m.Topic, Partition: m.Partition})
+		if err != nil {
+			return err
+		}
+		next = max(next, source.LowestOffset)
+	}
+	interval := m.ReportInterval
+	if interval == 0 {
+		interval = time.Second
+	}
+	reported := time.Now()
+	for {
+		if time.Since(reported) >= interval {
+			m.report(ctx, next)
+			reported = time.Now()
+		}
+		//a fetch returns everything the source holds from next on, so the gaps among its records are the source's
+		fetched, err := m.Source.Fetch(ctx, &log_v1.FetchRequest{
+			Topic:       m.Topic,
+			Partition:   m.Partition,
+			StartOffset: next,
+			MaxRecords:  uint32(m.batchSize()),
+		})
+		if status.Code(err) == status.Code(log_v1.ErrOffsetOutOfRange{}) {
+			err = m.wait(ctx, next, interval)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			return err
+		}
+		if len(fetched.GetRecords()) == 0 {
+			continue
+		}
+		res, err := m.Destination.AppendAt(ctx, &log_v1.AppendAtRequest{
+			Topic:       m.Topic,
+			Partition:   m.Partition,
+			Records:     fetched.Records,
+			NextOffset:  next,
+			SourceRange: true,
+		})
+		if err != nil {
+			return err
+		}
+		next = res.NextOffset
+	}
+}
+
+// wait long-polls the source until it has a record at or after next, or for as long as d. An offset the source
+// no longer holds fails the wait rather than being skipped
+func (m *Mirror) wait(ctx context.Context, next uint64, d time.Duration) error {
+	_, err := m.Source.Consume(ctx, &log_v1.ConsumeRequest{
+		Topic:     m.Topic,
+		Partition: m.Partition,
+		Offset:    next,
+		MaxWaitMs: max(d.Milliseconds(), 1),
+	})
+	return err
+}
+
+func (m *Mirror) report(ctx context.Context, next uint64) {
+	if m.Report == nil {
+		return
+	}
+	offsets, err := m.Source.GetOffsets(ctx, &log_v1.GetOffsetsRequest{Topic: m.Topic, Partition: m.Partition})
+	if err != nil {
+		return
+	}
+	m.Report(Progress{SourceNext: offsets.NextOffset, MirroredNext: next})
+}
+
+func (m *Mirror) batchSize() int {
+	if m.BatchSize == 0 {
+		return 100
+	}
+	return m.BatchSize
+}
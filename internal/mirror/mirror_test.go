@@ -0,0 +1,186 @@
+package mirror
+
+import (
+	"context"
+	"fmt"
+	log_v1 "github.com/mishamolnar/proglog/api/v1"
+	"github.com/mishamolnar/proglog/internal/log"
+	"github.com/mishamolnar/proglog/internal/server"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMirror(t *testing.T) {
+	for scenario, fn := range map[string]func(t *testing.T, src, dst *log.Log, m *Mirror){
+		"copies records keeping offsets": testMirrorCopy,
+		"resumes where it stopped":       testMirrorResume,
+		"starts at the source's lowest":  testMirrorRetention,
+		"keeps the gaps of compaction":   testMirrorCompacted,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			src, srcClient := setupServer(t, false)
+			dst, dstClient := setupServer(t, true)
+			fn(t, src, dst, &Mirror{Source: srcClient, Destination: dstClient, BatchSize: 7, ReportInterval: 10 * time.Millisecond})
+		})
+	}
+}
+
+func setupServer(t *testing.T, allowAppendAt bool) (*log.Log, log_v1.LogClient) {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "mirror-test")
+	require.NoError(t, err)
+	var c log.Config
+	c.Segment.MaxStoreBytes = 512
+	clog, err := log.NewLog(dir, c)
+	require.NoError(t, err)
+	srv, err := server.NewGRPCServer(&server.Config{CommitLog: clog, AllowAppendAt: allowAppendAt})
+	require.NoError(t, err)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(ln)
+	cc, err := grpc.Dial(ln.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		cc.Close()
+		srv.Stop()
+		clog.Remove()
+	})
+	return clog, log_v1.NewLogClient(cc)
+}
+
+func appendN(t *testing.T, l *log.Log, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		_, err := l.Append(&log_v1.Record{Value: []byte(fmt.Sprintf("record %d", i)), Key: []byte{byte(i)}})
+		require.NoError(t, err)
+	}
+}
+
+// run starts the mirror and returns a func that stops it and returns what Run returned
+func run(m *Mirror) func() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		errc <- m.Run(ctx)
+	}()
+	return func() error {
+		cancel()
+		return <-errc
+	}
+}
+
+func requireMirrored(t *testing.T, src, dst *log.Log) {
+	t.Helper()
+	srcNext, err := src.NextOffset()
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		next, _ := dst.NextOffset()
+		return next == srcNext
+	}, 3*time.Second, 10*time.Millisecond)
+	lowest, err := src.LowestOffset()
+	require.NoError(t, err)
+	for off := lowest; off < srcNext; off++ {
+		want, err := src.Read(off)
+		require.NoError(t, err)
+		got, err := dst.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, want.Offset, got.Offset)
+		require.Equal(t, want.Value, got.Value)
+		require.Equal(t, want.Key, got.Key)
+		require.Equal(t, want.Timestamp, got.Timestamp)
+	}
+}
+
+func testMirrorCopy(t *testing.T, src, dst *log.Log, m *Mirror) {
+	var mu sync.Mutex
+	var progress []Progress
+	m.Report = func(p Progress) {
+		mu.Lock()
+		defer mu.Unlock()
+		progress = append(progress, p)
+	}
+	appendN(t, src, 30)
+	stop := run(m)
+	requireMirrored(t, src, dst)
+	appendN(t, src, 30)
+	requireMirrored(t, src, dst)
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		last := len(progress) - 1
+		return last >= 0 && progress[last].Lag() == 0 && progress[last].MirroredNext == 60
+	}, time.Second, 10*time.Millisecond)
+	require.ErrorIs(t, stop(), context.Canceled)
+}
+
+func testMirrorResume(t *testing.T, src, dst *log.Log, m *Mirror) {
+	appendN(t, src, 20)
+	stop := run(m)
+	requireMirrored(t, src, dst)
+	stop()
+
+	appendN(t, src, 20)
+	stop = run(m)
+	defer stop()
+	requireMirrored(t, src, dst)
+}
+
+func testMirrorRetention(t *testing.T, src, dst *log.Log, m *Mirror) {
+	appendN(t, src, 60)
+	require.NoError(t, src.Truncate(30))
+	lowest, err := src.LowestOffset()
+	require.NoError(t, err)
+	require.Greater(t, lowest, uint64(0))
+
+	stop := run(m)
+	defer stop()
+	requireMirrored(t, src, dst)
+	dstLowest, err := dst.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, lowest, dstLowest)
+}
+
+func testMirrorCompacted(t *testing.T, src, dst *log.Log, m *Mirror) {
+	for i := 0; i < 60; i++ {
+		_, err := src.Append(&log_v1.Record{Value: []byte(fmt.Sprintf("record %d", i)), Key: []byte{byte(i % 5)}})
+		require.NoError(t, err)
+	}
+	_, err := src.Compact()
+	require.NoError(t, err)
+	gaps := 0
+	for off := uint64(0); off < 60; off++ {
+		rec, err := src.Read(off)
+		require.NoError(t, err)
+		if rec.Offset != off {
+			gaps++
+		}
+	}
+	require.Greater(t, gaps, 0)
+
+	stop := run(m)
+	requireMirrored(t, src, dst)
+	stop()
+
+	//a resumed mirror carries on past the gaps too
+	appendN(t, src, 5)
+	stop = run(m)
+	defer stop()
+	requireMirrored(t, src, dst)
+}
+
+func TestMirrorNeedsAppendAt(t *testing.T) {
+	src, srcClient := setupServer(t, false)
+	_, dstClient := setupServer(t, false)
+	appendN(t, src, 1)
+	m := &Mirror{Source: srcClient, Destination: dstClient}
+	err := m.Run(context.Background())
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	log_v1 "github.com/mishamolnar/proglog/api/v1"
+	"github.com/mishamolnar/proglog/internal/group"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+var errNoGroups = status.Error(codes.FailedPrecondition, "consumer groups are not enabled on this server")
+
+// offsetKey validates a group's request for the offsets of a partition that must exist
+func (c *Config) offsetKey(groupName, topic string, partition uint32) (group.Key, error) {
+	if c.Offsets == nil {
+		return group.Key{}, errNoGroups
+	}
+	if groupName == "" {
+		return group.Key{}, status.Error(codes.InvalidArgument, "committed offsets require a group")
+	}
+	if _, err := c.commitLog(topic, partition); err != nil {
+		return group.Key{}, err
+	}
+	return group.Key{Group: groupName, Topic: topic, Partition: partition}, nil
+}
+
+func (s *grpcServer) CommitOffset(ctx context.Context, req *log_v1.CommitOffsetRequest) (*log_v1.CommitOffsetResponse, error) {
+	k, err := s.offsetKey(req.Group, req.Topic, req.Partition)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.Offsets.Commit(k, req.Offset); err != nil {
+		return nil, err
+	}
+	return &log_v1.CommitOffsetResponse{}, nil
+}
+
+func (s *grpcServer) FetchCommittedOffset(ctx context.Context, req *log_v1.FetchCommittedOffsetRequest) (*log_v1.FetchCommittedOffsetResponse, error) {
+	k, err := s.offsetKey(req.Group, req.Topic, req.Partition)
+	if err != nil {
+		return nil, err
+	}
+	off, ok := s.Offsets.Fetch(k)
+	if !ok {
+		return nil, log_v1.ErrNoCommittedOffset{Group: req.Group, Topic: req.Topic, Partition: req.Partition}
+	}
+	return &log_v1.FetchCommittedOffsetResponse{Offset: off}, nil
+}
+
+func (s *grpcServer) JoinGroup(ctx context.Context, req *log_v1.JoinGroupRequest) (*log_v1.JoinGroupResponse, error) {
+	if s.Groups == nil {
+		return nil, errNoGroups
+	}
+	if req.Group == "" {
+		return nil, status.Error(codes.InvalidArgument, "joining requires a group")
+	}
+	m := group.Member{ID: req.MemberId, Topics: make(map[string]int)}
+	for _, topic := range req.Topics {
+		n, err := s.partitions(topic)
+		if err != nil {
+			return nil, err
+		}
+		m.Topics[topic] = n
+	}
+	a, err := s.Groups.Join(req.Group, m, req.Strategy, time.Duration(req.SessionTimeoutMs)*time.Millisecond)
+	if err != nil {
+		return nil, err
+	}
+	return &log_v1.JoinGroupResponse{
+		MemberId:   a.MemberID,
+		Generation: a.Generation,
+		Assignment: topicPartitionsProto(a.Partitions),
+	}, nil
+}
+
+func (s *grpcServer) Heartbeat(ctx context.Context, req *log_v1.HeartbeatRequest) (*log_v1.HeartbeatResponse, error) {
+	if s.Groups == nil {
+		return nil, errNoGroups
+	}
+	a, err := s.Groups.Heartbeat(req.Group, req.MemberId)
+	if err != nil {
+		return nil, err
+	}
+	return &log_v1.HeartbeatResponse{Generation: a.Generation, Assignment: topicPartitionsProto(a.Partitions)}, nil
+}
+
+func (s *grpcServer) LeaveGroup(ctx context.Context, req *log_v1.LeaveGroupRequest) (*log_v1.LeaveGroupResponse, error) {
+	if s.Groups == nil {
+		return nil, errNoGroups
+	}
+	if err := s.Groups.Leave(req.Group, req.MemberId); err != nil {
+		return nil, err
+	}
+	return &log_v1.LeaveGroupResponse{}, nil
+}
+
+// partitions counts the partitions of a topic, the default log being a single one
+func (c *Config) partitions(topic string) (int, error) {
+	if topic == "" {
+		return 1, nil
+	}
+	if c.Topics == nil {
+		return 0, log_v1.ErrTopicNotFound{Name: topic}
+	}
+	t, err := c.Topics.Topic(topic)
+	if err != nil {
+		return 0, err
+	}
+	return t.Partitions(), nil
+}
+
+func topicPartitionsProto(tps []group.TopicPartition) []*log_v1.TopicPartition {
+	res := make([]*log_v1.TopicPartition, len(tps))
+	for i, tp := range tps {
+		res[i] = &log_v1.TopicPartition{Topic: tp.Topic, Partition: tp.Partition}
+	}
+	return res
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	log_v1 "github.com/mishamolnar/proglog/api/v1"
+	"github.com/mishamolnar/proglog/internal/group"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestGroupMembership(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	addr, _, teardown := setupServer(t, func(c *Config) {
+		c.Groups = group.NewCoordinator(group.Config{SessionTimeout: 10 * time.Second, Now: clock.Now})
+	})
+	defer teardown()
+	ctx := context.Background()
+
+	admin, closeAdmin := newClient(t, addr)
+	defer closeAdmin()
+	_, err := admin.CreateTopic(ctx, &log_v1.CreateTopicRequest{Name: "orders", Config: &log_v1.TopicConfig{Partitions: 6}})
+	require.NoError(t, err)
+	_, err = admin.JoinGroup(ctx, &log_v1.JoinGroupRequest{Group: "workers", Topics: []string{"missing"}})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	//three workers, each with a connection of its own
+	workers := make([]log_v1.LogClient, 3)
+	ids := make([]string, 3)
+	for i := range workers {
+		client, closeClient := newClient(t, addr)
+		defer closeClient()
+		res, err := client.JoinGroup(ctx, &log_v1.JoinGroupRequest{Group: "workers", Topics: []string{"orders"}, Strategy: "sticky"})
+		require.NoError(t, err)
+		workers[i], ids[i] = client, res.MemberId
+	}
+
+	owners := func(members ...int) (int64, map[uint32]int) {
+		t.Helper()
+		var generation int64
+		owned := make(map[uint32]int)
+		for _, i := range members {
+			res, err := workers[i].Heartbeat(ctx, &log_v1.HeartbeatRequest{Group: "workers", MemberId: ids[i]})
+			require.NoError(t, err)
+			generation = res.Generation
+			require.Len(t, res.Assignment, 6/len(members))
+			for _, tp := range res.Assignment {
+				_, taken := owned[tp.Partition]
+				require.False(t, taken)
+				owned[tp.Partition] = i
+			}
+		}
+		require.Len(t, owned, 6)
+		return generation, owned
+	}
+	generation, before := owners(0, 1, 2)
+	require.Equal(t, int64(3), generation)
+
+	//worker 2 goes silent, the others keep heartbeating and take over its partitions
+	clock.Advance(6 * time.Second)
+	for _, i := range []int{0, 1} {
+		_, err = workers[i].Heartbeat(ctx, &log_v1.HeartbeatRequest{Group: "workers", MemberId: ids[i]})
+		require.NoError(t, err)
+	}
+	clock.Advance(6 * time.Second)
+	generation, after := owners(0, 1)
+	require.Equal(t, int64(4), generation)
+	for p, i := range before {
+		if i != 2 {
+			require.Equal(t, i, after[p])
+		}
+	}
+	_, err = workers[2].Heartbeat(ctx, &log_v1.HeartbeatRequest{Group: "workers", MemberId: ids[2]})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = workers[1].LeaveGroup(ctx, &log_v1.LeaveGroupRequest{Group: "workers", MemberId: ids[1]})
+	require.NoError(t, err)
+	generation, _ = owners(0)
+	require.Equal(t, int64(5), generation)
+}
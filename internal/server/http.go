@@ -2,19 +2,37 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/mishamolnar/proglog/api/v1"
 	"github.com/mishamolnar/proglog/internal/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"net/http"
-	"os"
+	"strings"
 )
 
-func NewHTTPServer(addr string) *http.Server {
-	httpsrc := newHTTPServer()
+// NewHTTPServer serves the JSON API: "/" for the default log, "/topics/{name}" for named topics and
+// "/groups/{group}/offsets" for consumer group offsets
+func NewHTTPServer(addr string, config *Config) *http.Server {
+	httpsrc := newHTTPServer(config)
 	r := chi.NewRouter()
 	r.Post("/", httpsrc.handleProduce)
 	r.Get("/", httpsrc.handleConsume)
+	r.Delete("/", httpsrc.handleDelete)
+	r.Get("/records", httpsrc.handleFetch)
+	r.Get("/topics", httpsrc.handleListTopics)
+	r.Route("/topics/{name}", func(r chi.Router) {
+		r.Put("/", httpsrc.handleCreateTopic)
+		r.Delete("/", httpsrc.handleDeleteTopic)
+		r.Post("/", httpsrc.handleProduce)
+		r.Get("/", httpsrc.handleConsume)
+		r.Delete("/keys", httpsrc.handleDelete)
+		r.Get("/records", httpsrc.handleFetch)
+	})
+	r.Post("/groups/{group}/offsets", httpsrc.handleCommitOffset)
+	r.Get("/groups/{group}/offsets", httpsrc.handleFetchCommittedOffset)
 	return &http.Server{
 		Addr:    addr,
 		Handler: r,
@@ -22,26 +40,46 @@ func NewHTTPServer(addr string) *http.Server {
 }
 
 type httpServer struct {
-	Log *log.Log
+	*Config
 }
 
-func newHTTPServer() *httpServer {
-	l, err := log.NewLog("/tmp/logs", log.Config{})
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+func newHTTPServer(config *Config) *httpServer {
+	if config.Partitioner == nil {
+		config.Partitioner = &log.HashPartitioner{}
 	}
 	return &httpServer{
-		Log: l,
+		Config: config,
+	}
+}
+
+// commitLog resolves the partition of the topic named by the request path, answering the request itself when there is none
+func (h *httpServer) commitLog(w http.ResponseWriter, r *http.Request, partition uint32) (CommitLog, bool) {
+	clog, err := h.Config.commitLog(chi.URLParam(r, "name"), partition)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil, false
+	}
+	return clog, true
+}
+
+func (h *httpServer) produceLog(w http.ResponseWriter, r *http.Request, key []byte, tombstone bool, partition *uint32) (CommitLog, uint32, bool) {
+	clog, p, err := h.Config.produceLog(chi.URLParam(r, "name"), key, tombstone, partition)
+	if err != nil {
+		httpError(w, err)
+		return nil, 0, false
 	}
+	return clog, p, true
 }
 
 type ProduceRequest struct {
 	Record log_v1.Record `json:"record"`
+	// Partition is picked from the record's key when left out
+	Partition *uint32 `json:"partition,omitempty"`
 }
 
 type ProducerResponse struct {
-	Offset uint64 `json:"offset"`
+	Offset    uint64 `json:"offset"`
+	Partition uint32 `json:"partition"`
 }
 
 func (h *httpServer) handleProduce(w http.ResponseWriter, r *http.Request) {
@@ -52,23 +90,73 @@ func (h *httpServer) handleProduce(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	offset, err := h.Log.Append(&req.Record)
+	if err = checkTombstone(&req.Record); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	clog, partition, ok := h.produceLog(w, r, req.Record.Key, req.Record.Tombstone, req.Partition)
+	if !ok {
+		return
+	}
+	offset, err := clog.Append(&req.Record)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	err = json.NewEncoder(w).Encode(ProducerResponse{Offset: offset})
+	err = json.NewEncoder(w).Encode(ProducerResponse{Offset: offset, Partition: partition})
 	if err != nil {
 		fmt.Printf("Could not write to response body %v \n", err)
 	}
 }
 
+type DeleteRequest struct {
+	Key       []byte  `json:"key"`
+	Partition *uint32 `json:"partition,omitempty"`
+}
+
+func (h *httpServer) handleDelete(w http.ResponseWriter, r *http.Request) {
+	var req DeleteRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Key) == 0 {
+		http.Error(w, "delete requires a key", http.StatusBadRequest)
+		return
+	}
+	clog, partition, ok := h.produceLog(w, r, req.Key, true, req.Partition)
+	if !ok {
+		return
+	}
+	offset, err := clog.Append(&log_v1.Record{Key: req.Key, Tombstone: true})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err = json.NewEncoder(w).Encode(ProducerResponse{Offset: offset, Partition: partition}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
 type ConsumerRequest struct {
 	Offset uint64 `json:"offset"`
+	// Timestamp in Unix nanoseconds, when set the offset is looked up by time instead
+	Timestamp int64  `json:"timestamp"`
+	Partition uint32 `json:"partition"`
+	// Start is one of "committed", "earliest" or "latest", the offset or timestamp is used when empty
+	Start string `json:"start"`
+	Group string `json:"group"`
+	// MaxWaitMs turns on long-polling, the response holds every readable record, up to 1MB, once MinRecords
+	// records of MinBytes bytes are there or the wait is over
+	MaxWaitMs  int64  `json:"max_wait_ms"`
+	MinRecords uint32 `json:"min_records"`
+	MinBytes   uint64 `json:"min_bytes"`
 }
 
 type ConsumerResponse struct {
-	Record *log_v1.Record `json:"record"`
+	Record  *log_v1.Record   `json:"record"`
+	Records []*log_v1.Record `json:"records"`
 }
 
 func (h *httpServer) handleConsume(w http.ResponseWriter, r *http.Request) {
@@ -78,19 +166,202 @@ func (h *httpServer) handleConsume(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	record, err := h.Log.Read(req.Offset)
+	start, ok := log_v1.StartPosition_value["START_"+strings.ToUpper(req.Start)]
+	if req.Start != "" && !ok {
+		http.Error(w, fmt.Sprintf("unknown start %q", req.Start), http.StatusBadRequest)
+		return
+	}
+	clog, ok := h.commitLog(w, r, req.Partition)
+	if !ok {
+		return
+	}
+	res, err := h.consume(r.Context(), clog, &log_v1.ConsumeRequest{
+		Offset:     req.Offset,
+		Timestamp:  req.Timestamp,
+		Topic:      chi.URLParam(r, "name"),
+		Partition:  req.Partition,
+		Start:      log_v1.StartPosition(start),
+		Group:      req.Group,
+		MaxWaitMs:  req.MaxWaitMs,
+		MinRecords: req.MinRecords,
+		MinBytes:   req.MinBytes,
+	})
+	if e, ok := err.(log_v1.ErrOffsetOutOfRange); ok {
+		http.Error(w, e.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	err = json.NewEncoder(w).Encode(ConsumerResponse{Record: res.Record, Records: res.Records})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+type FetchRequest struct {
+	Partition   uint32 `json:"partition"`
+	StartOffset uint64 `json:"start_offset"`
+	MaxRecords  uint32 `json:"max_records"`
+	MaxBytes    uint64 `json:"max_bytes"`
+}
+
+type FetchResponse struct {
+	Records    []*log_v1.Record `json:"records"`
+	NextOffset uint64           `json:"next_offset"`
+}
+
+func (h *httpServer) handleFetch(w http.ResponseWriter, r *http.Request) {
+	var req FetchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	clog, ok := h.commitLog(w, r, req.Partition)
+	if !ok {
+		return
+	}
+	res, err := fetch(clog, &log_v1.FetchRequest{StartOffset: req.StartOffset, MaxRecords: req.MaxRecords, MaxBytes: req.MaxBytes})
 	if e, ok := err.(log_v1.ErrOffsetOutOfRange); ok {
 		http.Error(w, e.Error(), http.StatusBadRequest)
 		return
 	}
 	if err != nil {
+		httpError(w, err)
+		return
+	}
+	if err = json.NewEncoder(w).Encode(FetchResponse{Records: res.Records, NextOffset: res.NextOffset}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// httpError answers with the HTTP status closest to the error's gRPC code
+func httpError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	switch status.Code(err) {
+	case codes.InvalidArgument:
+		code = http.StatusBadRequest
+	case codes.NotFound:
+		code = http.StatusNotFound
+	case codes.AlreadyExists:
+		code = http.StatusConflict
+	case codes.FailedPrecondition:
+		code = http.StatusNotImplemented
+	}
+	http.Error(w, err.Error(), code)
+}
+
+type OffsetRequest struct {
+	Topic     string `json:"topic"`
+	Partition uint32 `json:"partition"`
+	Offset    uint64 `json:"offset"`
+}
+
+type OffsetResponse struct {
+	Offset uint64 `json:"offset"`
+}
+
+func (h *httpServer) handleCommitOffset(w http.ResponseWriter, r *http.Request) {
+	var req OffsetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	k, err := h.offsetKey(chi.URLParam(r, "group"), req.Topic, req.Partition)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	if err = h.Offsets.Commit(k, req.Offset); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	res := ConsumerResponse{Record: record}
-	err = json.NewEncoder(w).Encode(res)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *httpServer) handleFetchCommittedOffset(w http.ResponseWriter, r *http.Request) {
+	var req OffsetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	k, err := h.offsetKey(chi.URLParam(r, "group"), req.Topic, req.Partition)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	off, ok := h.Offsets.Fetch(k)
+	if !ok {
+		httpError(w, log_v1.ErrNoCommittedOffset{Group: k.Group, Topic: k.Topic, Partition: k.Partition})
+		return
+	}
+	if err = json.NewEncoder(w).Encode(OffsetResponse{Offset: off}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+type TopicResponse struct {
+	Name   string          `json:"name"`
+	Config log.TopicConfig `json:"config"`
+}
+
+func (h *httpServer) handleListTopics(w http.ResponseWriter, r *http.Request) {
+	res := []TopicResponse{}
+	if h.Topics != nil {
+		for _, t := range h.Topics.ListTopics() {
+			res = append(res, TopicResponse{Name: t.Name, Config: t.Config})
+		}
+	}
+	err := json.NewEncoder(w).Encode(res)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 }
+
+// handleCreateTopic takes the topic's config overrides as the request body, an empty body keeps the defaults
+func (h *httpServer) handleCreateTopic(w http.ResponseWriter, r *http.Request) {
+	var tc log.TopicConfig
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&tc); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if h.Topics == nil {
+		http.Error(w, errNoTopics.Error(), http.StatusNotImplemented)
+		return
+	}
+	err := h.Topics.CreateTopic(chi.URLParam(r, "name"), tc)
+	var exists log_v1.ErrTopicExists
+	var invalid log_v1.ErrInvalidTopic
+	switch {
+	case errors.As(err, &exists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.As(err, &invalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+func (h *httpServer) handleDeleteTopic(w http.ResponseWriter, r *http.Request) {
+	if h.Topics == nil {
+		http.Error(w, errNoTopics.Error(), http.StatusNotImplemented)
+		return
+	}
+	err := h.Topics.DeleteTopic(chi.URLParam(r, "name"))
+	var notFound log_v1.ErrTopicNotFound
+	switch {
+	case errors.As(err, &notFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPLongPoll(t *testing.T) {
+	_, config, teardown := setupTest(t, nil)
+	defer teardown()
+	srv := httptest.NewServer(NewHTTPServer("", config).Handler)
+	defer srv.Close()
+
+	do := func(method string, body any, res any) int {
+		t.Helper()
+		return doHTTP(t, method, srv.URL, body, res)
+	}
+
+	//the producer runs off the test goroutine, so it reports back instead of asserting
+	produced := make(chan error, 1)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		resp, err := http.Post(srv.URL, "application/json", strings.NewReader(`{"record":{"value":"dGFpbA=="}}`))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				err = fmt.Errorf("produce: %s", resp.Status)
+			}
+		}
+		produced <- err
+	}()
+	var consumed ConsumerResponse
+	require.Equal(t, http.StatusOK, do(http.MethodGet, ConsumerRequest{MaxWaitMs: 5000}, &consumed))
+	require.NoError(t, <-produced)
+	require.Len(t, consumed.Records, 1)
+	require.Equal(t, []byte("tail"), consumed.Record.Value)
+
+	//without a wait the missing offset is still an error
+	require.Equal(t, http.StatusBadRequest, do(http.MethodGet, ConsumerRequest{Offset: 1}, nil))
+	consumed = ConsumerResponse{}
+	require.Equal(t, http.StatusOK, do(http.MethodGet, ConsumerRequest{Offset: 1, MaxWaitMs: 10}, &consumed))
+	require.Empty(t, consumed.Records)
+
+	//a long-poll answers with every record there is
+	for _, value := range []string{"a", "b", "c"} {
+		require.Equal(t, http.StatusOK, do(http.MethodPost, map[string]any{"record": map[string]any{"value": []byte(value)}}, nil))
+	}
+	consumed = ConsumerResponse{}
+	require.Equal(t, http.StatusOK, do(http.MethodGet, ConsumerRequest{Offset: 1, MaxWaitMs: 1000}, &consumed))
+	require.Len(t, consumed.Records, 3)
+	for i, value := range []string{"a", "b", "c"} {
+		require.Equal(t, []byte(value), consumed.Records[i].Value)
+	}
+}
+
+func TestHTTPRecordMetadata(t *testing.T) {
+	_, config, teardown := setupTest(t, nil)
+	defer teardown()
+	srv := httptest.NewServer(NewHTTPServer("", config).Handler)
+	defer srv.Close()
+
+	record := map[string]any{
+		"value":     []byte("Hello world"),
+		"key":       []byte("greeting"),
+		"headers":   []map[string]any{{"key": "lang", "value": []byte("en")}},
+		"timestamp": 1700000000000000000,
+	}
+	var produced ProducerResponse
+	require.Equal(t, http.StatusOK, doHTTP(t, http.MethodPost, srv.URL, map[string]any{"record": record}, &produced))
+	require.Equal(t, http.StatusOK, doHTTP(t, http.MethodPost, srv.URL, map[string]any{"record": map[string]any{"value": []byte("now")}}, nil))
+
+	var consumed ConsumerResponse
+	require.Equal(t, http.StatusOK, doHTTP(t, http.MethodGet, srv.URL, ConsumerRequest{Offset: produced.Offset}, &consumed))
+	require.Equal(t, []byte("greeting"), consumed.Record.Key)
+	require.Equal(t, int64(1700000000000000000), consumed.Record.Timestamp)
+	require.Len(t, consumed.Record.Headers, 1)
+	require.Equal(t, "lang", consumed.Record.Headers[0].Key)
+	require.Equal(t, []byte("en"), consumed.Record.Headers[0].Value)
+
+	//the log stamps records the producer left without a timestamp, and consuming by time finds them
+	consumed = ConsumerResponse{}
+	require.Equal(t, http.StatusOK, doHTTP(t, http.MethodGet, srv.URL, ConsumerRequest{Offset: produced.Offset + 1}, &consumed))
+	require.Greater(t, consumed.Record.Timestamp, int64(1700000000000000000))
+	stamped := consumed.Record.Timestamp
+	consumed = ConsumerResponse{}
+	require.Equal(t, http.StatusOK, doHTTP(t, http.MethodGet, srv.URL, ConsumerRequest{Timestamp: stamped}, &consumed))
+	require.Equal(t, produced.Offset+1, consumed.Record.Offset)
+}
+
+// doHTTP sends body as JSON and decodes a successful response into res, returning the status code
+func doHTTP(t *testing.T, method, url string, body any, res any) int {
+	t.Helper()
+	b, err := json.Marshal(body)
+	require.NoError(t, err)
+	req, err := http.NewRequest(method, url, bytes.NewReader(b))
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK && res != nil {
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(res))
+	}
+	return resp.StatusCode
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	log_v1 "github.com/mishamolnar/proglog/api/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var errNoAppendAt = status.Error(codes.PermissionDenied, "appending at offsets is not allowed on this server")
+
+// offsetAppender is a log that can store records at the offsets they carry
+type offsetAppender interface {
+	AppendAt(next uint64, records []*log_v1.Record) error
+	AppendRange(next uint64, records []*log_v1.Record) error
+}
+
+func (s *grpcServer) GetOffsets(ctx context.Context, req *log_v1.GetOffsetsRequest) (*log_v1.GetOffsetsResponse, error) {
+	clog, err := s.commitLog(req.Topic, req.Partition)
+	if err != nil {
+		return nil, err
+	}
+	lowest, err := clog.LowestOffset()
+	if err != nil {
+		return nil, err
+	}
+	next, err := clog.NextOffset()
+	if err != nil {
+		return nil, err
+	}
+	return &log_v1.GetOffsetsResponse{LowestOffset: lowest, NextOffset: next}, nil
+}
+
+func (s *grpcServer) AppendAt(ctx context.Context, req *log_v1.AppendAtRequest) (*log_v1.AppendAtResponse, error) {
+	if !s.AllowAppendAt {
+		return nil, errNoAppendAt
+	}
+	if len(req.Records) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "no records to append")
+	}
+	clog, err := s.commitLog(req.Topic, req.Partition)
+	if err != nil {
+		return nil, err
+	}
+	l, ok := clog.(offsetAppender)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "the log cannot append at offsets")
+	}
+	appendAt := l.AppendAt
+	if req.SourceRange {
+		appendAt = l.AppendRange
+	}
+	if err = appendAt(req.NextOffset, req.Records); err != nil {
+		return nil, err
+	}
+	return &log_v1.AppendAtResponse{NextOffset: req.Records[len(req.Records)-1].Offset + 1}, nil
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"context"
+	log_v1 "github.com/mishamolnar/proglog/api/v1"
+	"google.golang.org/grpc"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	replicateMinBackoff = 100 * time.Millisecond
+	replicateMaxBackoff = 10 * time.Second
+)
+
+// ReplicaLog is a log that takes records at the offsets they carry, log.Log is one
+type ReplicaLog interface {
+	NextOffset() (uint64, error)
+	AppendRange(next uint64, records []*log_v1.Record) error
+}
+
+// Replicator pulls the records of its peers with ConsumeStream and stores them in Local at their offsets, making
+// it a read replica. The peers hold copies of the same log, so a record already stored through one peer is
+// skipped when another sends it, and gaps compaction left in a peer's log stay gaps. Every peer is replicated
+// from where Local ends, so after a restart replication carries on where it stopped.
+// Join and Leave make it a discovery.Handler
+type Replicator struct {
+	DialOptions []grpc.DialOption
+	Local       ReplicaLog
+
+	// appendMu keeps the peers from appending to Local at the same time
+	appendMu sync.Mutex
+	logger   *log.Logger
+	mu       sync.Mutex
+	servers  map[string]chan struct{}
+	closed   bool
+	close    chan struct{}
+	wg       sync.WaitGroup
+}
+
+// Join starts replicating the peer at addr, a peer that is already being replicated is left alone
+func (r *Replicator) Join(name, addr string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.init()
+	if r.closed {
+		return nil
+	}
+	if _, ok := r.servers[name]; ok {
+		return nil
+	}
+	leave := make(chan struct{})
+	r.servers[name] = leave
+	r.wg.Add(1)
+	go r.replicate(name, addr, leave)
+	return nil
+}
+
+func (r *Replicator) Leave(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.init()
+	if leave, ok := r.servers[name]; ok {
+		close(leave)
+		delete(r.servers, name)
+	}
+	return nil
+}
+
+// Close stops replicating every peer and returns once nothing is appended to Local anymore
+func (r *Replicator) Close() error {
+	r.mu.Lock()
+	r.init()
+	if !r.closed {
+		r.closed = true
+		close(r.close)
+	}
+	r.mu.Unlock()
+	r.wg.Wait()
+	return nil
+}
+
+func (r *Replicator) init() {
+	if r.logger == nil {
+		r.logger = log.New(os.Stderr, "replicator: ", log.LstdFlags)
+	}
+	if r.servers == nil {
+		r.servers = make(map[string]chan struct{})
+	}
+	if r.close == nil {
+		r.close = make(chan struct{})
+	}
+}
+
+func (r *Replicator) replicate(name, addr string, leave chan struct{}) {
+	defer r.wg.Done()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-leave:
+		case <-r.close:
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+	cc, err := grpc.Dial(addr, r.DialOptions...)
+	if err != nil {
+		r.logger.Printf("failed to dial %s (%s): %v", name, addr, err)
+		return
+	}
+	defer cc.Close()
+	client := log_v1.NewLogClient(cc)
+
+	backoff := replicateMinBackoff
+	for {
+		next, err := r.Local.NextOffset()
+		if err == nil {
+			var progressed bool
+			progressed, err = r.stream(ctx, client, next)
+			if progressed {
+				backoff = replicateMinBackoff
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		r.logger.Printf("failed to replicate %s (%s): %v", name, addr, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, replicateMaxBackoff)
+	}
+}
+
+// stream stores the peer's records from next on until the stream breaks
+func (r *Replicator) stream(ctx context.Context, client log_v1.LogClient, next uint64) (bool, error) {
+	stream, err := client.ConsumeStream(ctx, &log_v1.ConsumeRequest{Offset: next})
+	if err != nil {
+		return false, err
+	}
+	progressed := false
+	for {
+		res, err := stream.Recv()
+		if err != nil {
+			return progressed, err
+		}
+		if err = r.store(res.Record); err != nil {
+			return progressed, err
+		}
+		progressed = true
+	}
+}
+
+// store appends the record unless another peer already did. The peer sent every record between where its stream
+// started and this one, so whatever lies between the end of Local and the record is a gap in the log
+func (r *Replicator) store(record *log_v1.Record) error {
+	r.appendMu.Lock()
+	defer r.appendMu.Unlock()
+	end, err := r.Local.NextOffset()
+	if err != nil {
+		return err
+	}
+	if record.Offset < end {
+		return nil
+	}
+	return r.Local.AppendRange(end, []*log_v1.Record{record})
+}
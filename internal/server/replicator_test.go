@@ -0,0 +1,246 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	log_v1 "github.com/mishamolnar/proglog/api/v1"
+	"github.com/mishamolnar/proglog/internal/log"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestReplicator(t *testing.T) {
+	for scenario, fn := range map[string]func(t *testing.T, upstream log_v1.LogClient, config *Config, addr string, local *log.Log){
+		"replicates and follows the peer":    testReplicateFollow,
+		"resumes where the local log ends":   testReplicateResume,
+		"stops replicating a peer that left": testReplicateLeave,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			addr, config, teardown := setupServer(t, nil)
+			defer teardown()
+			upstream, closeClient := newClient(t, addr)
+			defer closeClient()
+			fn(t, upstream, config, addr, newLocalLog(t))
+		})
+	}
+}
+
+func newLocalLog(t *testing.T) *log.Log {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "replicator-test")
+	require.NoError(t, err)
+	local, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+	t.Cleanup(func() { local.Remove() })
+	return local
+}
+
+func newReplicator(local *log.Log) *Replicator {
+	return &Replicator{
+		DialOptions: []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())},
+		Local:       local,
+	}
+}
+
+func produceN(t *testing.T, client log_v1.LogClient, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		_, err := client.Produce(context.Background(), &log_v1.ProduceRequest{Record: &log_v1.Record{Value: []byte(fmt.Sprintf("record %d", i))}})
+		require.NoError(t, err)
+	}
+}
+
+// requireMirrored waits until local ends where the upstream log does, n, and holds each of its records at the
+// same offset. Local may keep records the upstream compacted away since they were replicated
+func requireMirrored(t *testing.T, upstream *log.Log, local *log.Log, n uint64) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		next, _ := local.NextOffset()
+		return next == n
+	}, 3*time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	next, err := local.NextOffset()
+	require.NoError(t, err)
+	require.Equal(t, n, next)
+	it := upstream.NewIterator(0)
+	defer it.Close()
+	for it.Next() {
+		want := it.Record()
+		got, err := local.Read(want.Offset)
+		require.NoError(t, err)
+		require.Equal(t, want.Offset, got.Offset)
+		require.Equal(t, want.Value, got.Value)
+		require.Equal(t, want.Timestamp, got.Timestamp)
+	}
+	require.NoError(t, it.Err())
+}
+
+// setupUpstream serves l on a loopback port
+func setupUpstream(t *testing.T, l *log.Log) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv, err := NewGRPCServer(&Config{CommitLog: l})
+	require.NoError(t, err)
+	go srv.Serve(ln)
+	t.Cleanup(srv.Stop)
+	return ln.Addr().String()
+}
+
+// appendKeys appends n records with keys repeating every five records, so compaction leaves gaps
+func appendKeys(t *testing.T, from int, n int, logs ...*log.Log) {
+	t.Helper()
+	for i := from; i < from+n; i++ {
+		for _, l := range logs {
+			record := &log_v1.Record{Value: []byte(fmt.Sprintf("record %d", i)), Key: []byte{byte(i % 5)}, Timestamp: int64(i + 1)}
+			_, err := l.Append(record)
+			require.NoError(t, err)
+		}
+	}
+}
+
+func TestReplicatorCompactedPeer(t *testing.T) {
+	dir, err := os.MkdirTemp("", "replicator-test")
+	require.NoError(t, err)
+	var c log.Config
+	c.Segment.MaxStoreBytes = 256
+	upstream, err := log.NewLog(dir, c)
+	require.NoError(t, err)
+	defer upstream.Remove()
+	appendKeys(t, 0, 40, upstream)
+	_, err = upstream.Compact()
+	require.NoError(t, err)
+	addr := setupUpstream(t, upstream)
+
+	local := newLocalLog(t)
+	r := newReplicator(local)
+	require.NoError(t, r.Join("upstream", addr))
+	requireMirrored(t, upstream, local, 40)
+	require.NoError(t, r.Close())
+
+	appendKeys(t, 40, 20, upstream)
+	_, err = upstream.Compact()
+	require.NoError(t, err)
+	r = newReplicator(local)
+	defer r.Close()
+	require.NoError(t, r.Join("upstream", addr))
+	requireMirrored(t, upstream, local, 60)
+}
+
+func TestReplicatorPeers(t *testing.T) {
+	peers := []*log.Log{newLocalLog(t), newLocalLog(t)}
+	appendKeys(t, 0, 10, peers...)
+	appendKeys(t, 10, 5, peers[1])
+
+	local := newLocalLog(t)
+	r := newReplicator(local)
+	require.NoError(t, r.Join("0", setupUpstream(t, peers[0])))
+	require.NoError(t, r.Join("1", setupUpstream(t, peers[1])))
+	requireMirrored(t, peers[1], local, 15)
+
+	//the lagging peer catches up, neither of them duplicates the records the other sent
+	appendKeys(t, 10, 5, peers[0])
+	appendKeys(t, 15, 5, peers...)
+	requireMirrored(t, peers[0], local, 20)
+	require.NoError(t, r.Close())
+
+	appendKeys(t, 20, 5, peers[0])
+	appendKeys(t, 20, 10, peers[1])
+	r = newReplicator(local)
+	defer r.Close()
+	require.NoError(t, r.Join("0", setupUpstream(t, peers[0])))
+	require.NoError(t, r.Join("1", setupUpstream(t, peers[1])))
+	requireMirrored(t, peers[1], local, 30)
+}
+
+func testReplicateFollow(t *testing.T, upstream log_v1.LogClient, config *Config, addr string, local *log.Log) {
+	produceN(t, upstream, 10)
+	r := newReplicator(local)
+	defer r.Close()
+	require.NoError(t, r.Join("upstream", addr))
+	requireMirrored(t, config.CommitLog.(*log.Log), local, 10)
+
+	produceN(t, upstream, 5)
+	requireMirrored(t, config.CommitLog.(*log.Log), local, 15)
+}
+
+func testReplicateResume(t *testing.T, upstream log_v1.LogClient, config *Config, addr string, local *log.Log) {
+	produceN(t, upstream, 10)
+	r := newReplicator(local)
+	require.NoError(t, r.Join("upstream", addr))
+	requireMirrored(t, config.CommitLog.(*log.Log), local, 10)
+	require.NoError(t, r.Close())
+
+	produceN(t, upstream, 10)
+	r = newReplicator(local)
+	defer r.Close()
+	require.NoError(t, r.Join("upstream", addr))
+	requireMirrored(t, config.CommitLog.(*log.Log), local, 20)
+}
+
+func testReplicateLeave(t *testing.T, upstream log_v1.LogClient, config *Config, addr string, local *log.Log) {
+	produceN(t, upstream, 3)
+	r := newReplicator(local)
+	defer r.Close()
+	require.NoError(t, r.Join("upstream", addr))
+	requireMirrored(t, config.CommitLog.(*log.Log), local, 3)
+
+	require.NoError(t, r.Leave("upstream"))
+	produceN(t, upstream, 3)
+	time.Sleep(200 * time.Millisecond)
+	next, err := local.NextOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), next)
+}
+
+func TestReplicatorBackoff(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	require.NoError(t, ln.Close())
+
+	local := newLocalLog(t)
+	r := newReplicator(local)
+	defer r.Close()
+	require.NoError(t, r.Join("upstream", addr))
+	time.Sleep(300 * time.Millisecond) //a few failed attempts
+
+	upstreamLog := newLocalLog(t)
+	for i := 0; i < 5; i++ {
+		_, err = upstreamLog.Append(&log_v1.Record{Value: []byte(fmt.Sprintf("record %d", i))})
+		require.NoError(t, err)
+	}
+	ln, err = net.Listen("tcp", addr)
+	require.NoError(t, err)
+	srv, err := NewGRPCServer(&Config{CommitLog: upstreamLog})
+	require.NoError(t, err)
+	go srv.Serve(ln)
+	defer srv.Stop()
+	requireMirrored(t, upstreamLog, local, 5)
+}
+
+func TestReplicatorCloseStopsStreams(t *testing.T) {
+	addr, _, teardown := setupServer(t, nil)
+	defer teardown()
+	r := newReplicator(newLocalLog(t))
+	require.NoError(t, r.Join("upstream", addr))
+	require.NoError(t, r.Join("upstream", addr))
+	done := make(chan struct{})
+	go func() {
+		r.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Close did not return")
+	}
+	//joins after Close are ignored
+	require.NoError(t, r.Join("other", addr))
+	require.NotContains(t, r.servers, "other")
+}
@@ -2,12 +2,32 @@ package server
 
 import (
 	"context"
+	"errors"
 	log_v1 "github.com/mishamolnar/proglog/api/v1"
+	"github.com/mishamolnar/proglog/internal/group"
+	"github.com/mishamolnar/proglog/internal/log"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"time"
 )
 
 type Config struct {
+	// CommitLog serves requests that name no topic
 	CommitLog CommitLog
+	// Topics serves requests naming a topic, nil when the server has none
+	Topics *log.TopicManager
+	// Partitioner picks a topic partition for records produced without one, key hashing when nil
+	Partitioner log.Partitioner
+	// Offsets stores consumer group offsets, nil when the server has no consumer groups
+	Offsets *group.Offsets
+	// Groups coordinates consumer group membership, nil when the server has no consumer groups
+	Groups *group.Coordinator
+	// AllowAppendAt lets clients write records at offsets of their choosing, for servers that are mirror targets
+	AllowAppendAt bool
+	// ServerGetter lists the servers holding the log for client-side load balancing, nil when there are none
+	ServerGetter ServerGetter
 }
 
 var _ log_v1.LogServer = (*grpcServer)(nil)
@@ -28,24 +48,176 @@ func NewGRPCServer(config *Config) (*grpc.Server, error) {
 }
 
 func newGrpcServer(config *Config) (srv *grpcServer, err error) {
+	if config.Partitioner == nil {
+		config.Partitioner = &log.HashPartitioner{}
+	}
 	srv = &grpcServer{Config: config}
 	return srv, nil
 }
 
 func (s *grpcServer) Produce(ctx context.Context, req *log_v1.ProduceRequest) (*log_v1.ProduceResponse, error) {
-	offset, err := s.CommitLog.Append(req.Record)
+	if err := checkTombstone(req.Record); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	clog, partition, err := s.produceLog(req.Topic, req.Record.GetKey(), req.Record.GetTombstone(), req.Partition)
+	if err != nil {
+		return nil, err
+	}
+	offset, err := clog.Append(req.Record)
+	if err != nil {
+		return nil, err
+	}
+	return &log_v1.ProduceResponse{Offset: offset, Partition: partition}, nil
+}
+
+// checkTombstone rejects tombstones the compactor would not recognize as deleting a key
+func checkTombstone(r *log_v1.Record) error {
+	switch {
+	case !r.GetTombstone():
+		return nil
+	case len(r.Key) == 0:
+		return errors.New("a tombstone requires a key")
+	case len(r.Value) != 0:
+		return errors.New("a tombstone cannot carry a value")
+	}
+	return nil
+}
+
+// Delete appends a tombstone for the key, consumers see it as a record with Tombstone set
+func (s *grpcServer) Delete(ctx context.Context, req *log_v1.DeleteRequest) (*log_v1.DeleteResponse, error) {
+	if len(req.Key) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "delete requires a key")
+	}
+	clog, partition, err := s.produceLog(req.Topic, req.Key, true, req.Partition)
+	if err != nil {
+		return nil, err
+	}
+	offset, err := clog.Append(&log_v1.Record{Key: req.Key, Tombstone: true})
 	if err != nil {
 		return nil, err
 	}
-	return &log_v1.ProduceResponse{Offset: offset}, nil
+	return &log_v1.DeleteResponse{Offset: offset, Partition: partition}, nil
 }
 
 func (s *grpcServer) Consume(ctx context.Context, req *log_v1.ConsumeRequest) (*log_v1.ConsumeResponse, error) {
-	rec, err := s.CommitLog.Read(req.Offset)
+	clog, err := s.commitLog(req.Topic, req.Partition)
+	if err != nil {
+		return nil, err
+	}
+	return s.consume(ctx, clog, req)
+}
+
+// fetchMaxBytes caps a fetch response well below gRPC's default 4MB message limit
+const fetchMaxBytes = 1 << 20
+
+func (s *grpcServer) Fetch(ctx context.Context, req *log_v1.FetchRequest) (*log_v1.FetchResponse, error) {
+	clog, err := s.commitLog(req.Topic, req.Partition)
+	if err != nil {
+		return nil, err
+	}
+	return fetch(clog, req)
+}
+
+func fetch(clog CommitLog, req *log_v1.FetchRequest) (*log_v1.FetchResponse, error) {
+	maxBytes := req.MaxBytes
+	if maxBytes == 0 || maxBytes > fetchMaxBytes {
+		maxBytes = fetchMaxBytes
+	}
+	records, err := clog.ReadRange(req.StartOffset, int(req.MaxRecords), maxBytes)
+	if err != nil {
+		return nil, err
+	}
+	return &log_v1.FetchResponse{Records: records, NextOffset: records[len(records)-1].Offset + 1}, nil
+}
+
+// consume reads the record the request starts at, or long-polls for records when it has a max wait. A long-poll
+// returns everything readable once the minimums are met, up to fetchMaxBytes, and the minimums are capped to it
+func (c *Config) consume(ctx context.Context, clog CommitLog, req *log_v1.ConsumeRequest) (*log_v1.ConsumeResponse, error) {
+	off, err := c.startOffset(clog, req)
 	if err != nil {
 		return nil, err
 	}
-	return &log_v1.ConsumeResponse{Record: rec}, nil
+	if req.MaxWaitMs <= 0 {
+		rec, err := clog.Read(off)
+		if err != nil {
+			return nil, err
+		}
+		return &log_v1.ConsumeResponse{Record: rec, Records: []*log_v1.Record{rec}}, nil
+	}
+	wait, cancel := context.WithTimeout(ctx, time.Duration(req.MaxWaitMs)*time.Millisecond)
+	defer cancel()
+	minRecords, minBytes := int(max(req.MinRecords, 1)), min(req.MinBytes, fetchMaxBytes)
+	res := &log_v1.ConsumeResponse{}
+	var size uint64
+	for size < fetchMaxBytes {
+		records, err := clog.ReadRange(off, 0, fetchMaxBytes-size)
+		if _, ok := err.(log_v1.ErrOffsetOutOfRange); ok {
+			lowest, err := clog.LowestOffset()
+			if err != nil {
+				return nil, err
+			}
+			if off < lowest {
+				return nil, log_v1.ErrOffsetOutOfRange{Offset: off}
+			}
+			if len(res.Records) >= minRecords && size >= minBytes {
+				break
+			}
+			if err = clog.Wait(wait, off); err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				if wait.Err() == nil { //the log went away rather than the wait running out
+					return nil, err
+				}
+				break
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, rec := range records {
+			n := uint64(proto.Size(rec))
+			if len(res.Records) > 0 && size+n > fetchMaxBytes { //only a first record may go over the cap
+				size = fetchMaxBytes
+				break
+			}
+			res.Records = append(res.Records, rec)
+			size += n
+			off = rec.Offset + 1
+		}
+	}
+	if len(res.Records) > 0 {
+		res.Record = res.Records[0]
+	}
+	return res, nil
+}
+
+// startOffset resolves where a consume request starts from its start position, looking the offset up by time
+// when a timestamp is given
+func (c *Config) startOffset(clog CommitLog, req *log_v1.ConsumeRequest) (uint64, error) {
+	switch req.Start {
+	case log_v1.StartPosition_START_COMMITTED:
+		if c.Offsets == nil {
+			return 0, errNoGroups
+		}
+		if req.Group == "" {
+			return 0, status.Error(codes.InvalidArgument, "consuming from the committed offset requires a group")
+		}
+		off, ok := c.Offsets.Fetch(group.Key{Group: req.Group, Topic: req.Topic, Partition: req.Partition})
+		if ok {
+			return off, nil
+		}
+		return clog.LowestOffset()
+	case log_v1.StartPosition_START_EARLIEST:
+		return clog.LowestOffset()
+	case log_v1.StartPosition_START_LATEST:
+		return clog.NextOffset()
+	}
+	if req.Timestamp == 0 {
+		return req.Offset, nil
+	}
+	return clog.OffsetForTime(time.Unix(0, req.Timestamp))
 }
 
 func (s *grpcServer) ProduceStream(stream log_v1.Log_ProduceStreamServer) error {
@@ -65,28 +237,53 @@ func (s *grpcServer) ProduceStream(stream log_v1.Log_ProduceStreamServer) error
 }
 
 func (s *grpcServer) ConsumeStream(req *log_v1.ConsumeRequest, stream log_v1.Log_ConsumeStreamServer) error {
+	clog, err := s.commitLog(req.Topic, req.Partition)
+	if err != nil {
+		return err
+	}
+	off, err := s.startOffset(clog, req)
+	if err != nil {
+		return err
+	}
+	req.Offset, req.Timestamp, req.Start = off, 0, log_v1.StartPosition_START_OFFSET
+	req.MaxWaitMs, req.MinRecords, req.MinBytes = 0, 0, 0 //the stream waits on its own
+	ctx := stream.Context()
 	for {
-		select {
-		case <-stream.Context().Done():
-			return nil
-		default:
-			res, err := s.Consume(stream.Context(), req)
-			switch err.(type) {
-			case nil:
-			case log_v1.ErrOffsetOutOfRange:
-				continue
-			default:
+		res, err := s.Consume(ctx, req)
+		switch err.(type) {
+		case nil:
+		case log_v1.ErrOffsetOutOfRange:
+			lowest, err := clog.LowestOffset()
+			if err != nil {
 				return err
 			}
-			if err = stream.Send(res); err != nil {
+			if req.Offset < lowest { //retention deleted the records the consumer was behind on, it has to know
+				return log_v1.ErrOffsetOutOfRange{Offset: req.Offset}
+			}
+			if err = clog.Wait(ctx, req.Offset); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
 				return err
 			}
-			req.Offset++
+			continue
+		default:
+			return err
+		}
+		if err = stream.Send(res); err != nil {
+			return err
 		}
+		req.Offset = res.Record.Offset + 1 //compaction may have left gaps
 	}
 }
 
 type CommitLog interface {
 	Append(record *log_v1.Record) (uint64, error)
 	Read(uint64) (*log_v1.Record, error)
+	ReadRange(off uint64, maxRecords int, maxBytes uint64) ([]*log_v1.Record, error)
+	OffsetForTime(time.Time) (uint64, error)
+	LowestOffset() (uint64, error)
+	NextOffset() (uint64, error)
+	// Wait blocks until the log holds offsets at or past the given one
+	Wait(ctx context.Context, off uint64) error
 }
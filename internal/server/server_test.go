@@ -3,38 +3,61 @@ package server
 import (
 	"context"
 	log_v1 "github.com/mishamolnar/proglog/api/v1"
+	"github.com/mishamolnar/proglog/internal/group"
 	"github.com/mishamolnar/proglog/internal/log"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 	"net"
 	"os"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestServer(t *testing.T) {
 	for scenario, fn := range map[string]func(t *testing.T, client log_v1.LogClient, config *Config){
 		"produce/consume a message to/from log succeeds": testProduceConsume,
 		"consume past log boundary fails":                testConsumePastLogBoundaryFails,
+		"streams fail on records lost to retention":      testConsumeStreamRetention,
 		"produce stream succeeds":                        testProduceStream,
+		"record metadata survives produce/consume":       testRecordMetadata,
+		"consume from a timestamp":                       testConsumeFromTimestamp,
+		"delete produces a tombstone":                    testDelete,
+		"topics are created, used and deleted":           testTopics,
+		"records are partitioned by key":                 testPartitions,
+		"consumer groups resume from committed offsets":  testConsumerGroups,
+		"consume long-polls for records":                 testLongPoll,
+		"fetch reads a range of records":                 testFetch,
+		"servers are listed when discovery is enabled":   testGetServers,
 	} {
 		t.Run(scenario, func(t *testing.T) {
-			client, config, teardown := setupTest(t)
+			client, config, teardown := setupTest(t, nil)
 			defer teardown()
 			fn(t, client, config)
 		})
 	}
 }
 
-func setupTest(t *testing.T) (log_v1.LogClient, *Config, func()) { //creates server and returns log client!, and not server itself. Also config and teardown function
+func setupTest(t *testing.T, fn func(*Config)) (log_v1.LogClient, *Config, func()) { //creates server and returns log client!, and not server itself. Also config and teardown function
 	t.Helper()
-	listener, err := net.Listen("tcp", ":0")
-	require.NoError(t, err)
+	addr, cfg, stop := setupServer(t, fn)
+	client, closeClient := newClient(t, addr)
+	return client, cfg, func() {
+		closeClient()
+		stop()
+	}
+}
 
-	clientOptions := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
-	clientConn, err := grpc.Dial(listener.Addr().String(), clientOptions...)
+// setupServer starts a server on a loopback port, fn may change its config before it starts serving
+func setupServer(t *testing.T, fn func(*Config)) (string, *Config, func()) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
 	require.NoError(t, err)
 
 	dir, err := os.MkdirTemp("", "server-test")
@@ -43,22 +66,41 @@ func setupTest(t *testing.T) (log_v1.LogClient, *Config, func()) { //creates ser
 	clog, err := log.NewLog(dir, log.Config{})
 	require.NoError(t, err)
 
-	cfg := &Config{CommitLog: clog}
+	topics, err := log.NewTopicManager(filepath.Join(dir, "topics"), log.Config{})
+	require.NoError(t, err)
+
+	offsets, err := group.NewOffsets(filepath.Join(dir, "offsets"), log.Config{})
+	require.NoError(t, err)
+
+	cfg := &Config{CommitLog: clog, Topics: topics, Offsets: offsets, Groups: group.NewCoordinator(group.Config{})}
+	if fn != nil {
+		fn(cfg)
+	}
 	server, err := NewGRPCServer(cfg)
 	require.NoError(t, err)
 
 	go func() {
 		server.Serve(listener)
 	}()
-	client := log_v1.NewLogClient(clientConn)
-	return client, cfg, func() {
+	return listener.Addr().String(), cfg, func() {
 		server.Stop()
-		clientConn.Close()
 		listener.Close()
+		topics.Close()
+		offsets.Close()
 		clog.Remove()
 	}
 }
 
+func newClient(t *testing.T, addr string) (log_v1.LogClient, func()) {
+	t.Helper()
+	clientOptions := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	clientConn, err := grpc.Dial(addr, clientOptions...)
+	require.NoError(t, err)
+	return log_v1.NewLogClient(clientConn), func() {
+		clientConn.Close()
+	}
+}
+
 func testProduceConsume(t *testing.T, client log_v1.LogClient, config *Config) {
 	ctx := context.Background()
 	want := &log_v1.Record{Value: []byte("Hello world")}
@@ -71,6 +113,340 @@ func testProduceConsume(t *testing.T, client log_v1.LogClient, config *Config) {
 	require.Equal(t, want.Offset, consume.Record.Offset)
 }
 
+func testRecordMetadata(t *testing.T, client log_v1.LogClient, config *Config) {
+	ctx := context.Background()
+	want := &log_v1.Record{
+		Value:     []byte("Hello world"),
+		Key:       []byte("greeting"),
+		Headers:   []*log_v1.Header{{Key: "lang", Value: []byte("en")}},
+		Timestamp: 1700000000000000000,
+	}
+	produce, err := client.Produce(ctx, &log_v1.ProduceRequest{Record: want})
+	require.NoError(t, err)
+	consume, err := client.Consume(ctx, &log_v1.ConsumeRequest{Offset: produce.Offset})
+	require.NoError(t, err)
+	require.Equal(t, want.Key, consume.Record.Key)
+	require.Equal(t, want.Timestamp, consume.Record.Timestamp)
+	require.Len(t, consume.Record.Headers, 1)
+	require.Equal(t, want.Headers[0].Key, consume.Record.Headers[0].Key)
+	require.Equal(t, want.Headers[0].Value, consume.Record.Headers[0].Value)
+
+	//the log stamps records the producer left without a timestamp
+	produce, err = client.Produce(ctx, &log_v1.ProduceRequest{Record: &log_v1.Record{Value: []byte("now")}})
+	require.NoError(t, err)
+	consume, err = client.Consume(ctx, &log_v1.ConsumeRequest{Offset: produce.Offset})
+	require.NoError(t, err)
+	require.NotZero(t, consume.Record.Timestamp)
+}
+
+func testConsumeFromTimestamp(t *testing.T, client log_v1.LogClient, config *Config) {
+	ctx := context.Background()
+	base := time.Unix(1700000000, 0)
+	for i := 0; i < 3; i++ {
+		_, err := client.Produce(ctx, &log_v1.ProduceRequest{Record: &log_v1.Record{
+			Value:     []byte{byte(i)},
+			Timestamp: base.Add(time.Duration(i) * time.Minute).UnixNano(),
+		}})
+		require.NoError(t, err)
+	}
+	since := base.Add(30 * time.Second).UnixNano()
+
+	consume, err := client.Consume(ctx, &log_v1.ConsumeRequest{Timestamp: since})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), consume.Record.Offset)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := client.ConsumeStream(ctx, &log_v1.ConsumeRequest{Timestamp: since})
+	require.NoError(t, err)
+	for _, want := range []uint64{1, 2} {
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, want, res.Record.Offset)
+	}
+}
+
+func testDelete(t *testing.T, client log_v1.LogClient, config *Config) {
+	ctx := context.Background()
+	key := []byte("user-1")
+	for _, value := range [][]byte{[]byte("alice"), {}} {
+		_, err := client.Produce(ctx, &log_v1.ProduceRequest{Record: &log_v1.Record{Key: key, Value: value}})
+		require.NoError(t, err)
+	}
+	del, err := client.Delete(ctx, &log_v1.DeleteRequest{Key: key})
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), del.Offset)
+
+	_, err = client.Delete(ctx, &log_v1.DeleteRequest{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	for _, r := range []*log_v1.Record{{Tombstone: true}, {Key: key, Value: []byte("bob"), Tombstone: true}} {
+		_, err = client.Produce(ctx, &log_v1.ProduceRequest{Record: r})
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := client.ConsumeStream(ctx, &log_v1.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+	for _, tombstone := range []bool{false, false, true} {
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, key, res.Record.Key)
+		require.Equal(t, tombstone, res.Record.Tombstone)
+	}
+}
+
+func testTopics(t *testing.T, client log_v1.LogClient, config *Config) {
+	ctx := context.Background()
+	_, err := client.CreateTopic(ctx, &log_v1.CreateTopicRequest{
+		Name:   "orders",
+		Config: &log_v1.TopicConfig{RetentionMs: 60000},
+	})
+	require.NoError(t, err)
+	_, err = client.CreateTopic(ctx, &log_v1.CreateTopicRequest{Name: "orders"})
+	require.Equal(t, codes.AlreadyExists, status.Code(err))
+	_, err = client.CreateTopic(ctx, &log_v1.CreateTopicRequest{Name: "../orders"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	list, err := client.ListTopics(ctx, &log_v1.ListTopicsRequest{})
+	require.NoError(t, err)
+	require.Len(t, list.Topics, 1)
+	require.Equal(t, "orders", list.Topics[0].Name)
+	require.Equal(t, int64(60000), list.Topics[0].Config.RetentionMs)
+
+	//the default log and the topic keep separate offsets
+	_, err = client.Produce(ctx, &log_v1.ProduceRequest{Record: &log_v1.Record{Value: []byte("default")}})
+	require.NoError(t, err)
+	produce, err := client.Produce(ctx, &log_v1.ProduceRequest{Topic: "orders", Record: &log_v1.Record{Value: []byte("order")}})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), produce.Offset)
+	consume, err := client.Consume(ctx, &log_v1.ConsumeRequest{Topic: "orders", Offset: 0})
+	require.NoError(t, err)
+	require.Equal(t, []byte("order"), consume.Record.Value)
+
+	_, err = client.DeleteTopic(ctx, &log_v1.DeleteTopicRequest{Name: "orders"})
+	require.NoError(t, err)
+	_, err = client.Consume(ctx, &log_v1.ConsumeRequest{Topic: "orders", Offset: 0})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.Produce(ctx, &log_v1.ProduceRequest{Topic: "orders", Record: &log_v1.Record{Value: []byte("late")}})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.DeleteTopic(ctx, &log_v1.DeleteTopicRequest{Name: "orders"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func testPartitions(t *testing.T, client log_v1.LogClient, config *Config) {
+	ctx := context.Background()
+	_, err := client.CreateTopic(ctx, &log_v1.CreateTopicRequest{
+		Name:   "orders",
+		Config: &log_v1.TopicConfig{Partitions: 4},
+	})
+	require.NoError(t, err)
+
+	//a key always lands in the same partition, one record after another
+	key := []byte("customer-1")
+	var partition uint32
+	for i := 0; i < 3; i++ {
+		produce, err := client.Produce(ctx, &log_v1.ProduceRequest{
+			Topic:  "orders",
+			Record: &log_v1.Record{Key: key, Value: []byte{byte(i)}},
+		})
+		require.NoError(t, err)
+		if i > 0 {
+			require.Equal(t, partition, produce.Partition)
+		}
+		partition = produce.Partition
+		require.Equal(t, uint64(i), produce.Offset)
+	}
+	consume, err := client.Consume(ctx, &log_v1.ConsumeRequest{Topic: "orders", Partition: partition, Offset: 2})
+	require.NoError(t, err)
+	require.Equal(t, []byte{2}, consume.Record.Value)
+
+	explicit := (partition + 1) % 4
+	produce, err := client.Produce(ctx, &log_v1.ProduceRequest{
+		Topic:     "orders",
+		Partition: &explicit,
+		Record:    &log_v1.Record{Key: key, Value: []byte("moved")},
+	})
+	require.NoError(t, err)
+	require.Equal(t, explicit, produce.Partition)
+	require.Equal(t, uint64(0), produce.Offset)
+
+	missing := uint32(4)
+	_, err = client.Produce(ctx, &log_v1.ProduceRequest{Topic: "orders", Partition: &missing, Record: &log_v1.Record{}})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.Consume(ctx, &log_v1.ConsumeRequest{Topic: "orders", Partition: missing})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	del, err := client.Delete(ctx, &log_v1.DeleteRequest{Topic: "orders", Key: key})
+	require.NoError(t, err)
+	require.Equal(t, partition, del.Partition)
+	require.Equal(t, uint64(3), del.Offset)
+}
+
+// TestTombstonePartition checks that tombstones are not spread away from their key when records are round-robin
+func TestTombstonePartition(t *testing.T) {
+	client, _, teardown := setupTest(t, func(c *Config) {
+		c.Partitioner = &log.RoundRobinPartitioner{}
+	})
+	defer teardown()
+	ctx := context.Background()
+	_, err := client.CreateTopic(ctx, &log_v1.CreateTopicRequest{
+		Name:   "orders",
+		Config: &log_v1.TopicConfig{Partitions: 4},
+	})
+	require.NoError(t, err)
+
+	key := []byte("customer-1")
+	_, err = client.Delete(ctx, &log_v1.DeleteRequest{Topic: "orders", Key: key})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.Produce(ctx, &log_v1.ProduceRequest{Topic: "orders", Record: &log_v1.Record{Key: key, Tombstone: true}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	//naming the partition the key's records went to works
+	produce, err := client.Produce(ctx, &log_v1.ProduceRequest{Topic: "orders", Record: &log_v1.Record{Key: key, Value: []byte("v")}})
+	require.NoError(t, err)
+	del, err := client.Delete(ctx, &log_v1.DeleteRequest{Topic: "orders", Key: key, Partition: &produce.Partition})
+	require.NoError(t, err)
+	require.Equal(t, produce.Partition, del.Partition)
+}
+
+func testConsumerGroups(t *testing.T, client log_v1.LogClient, config *Config) {
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		_, err := client.Produce(ctx, &log_v1.ProduceRequest{Record: &log_v1.Record{Value: []byte{byte(i)}}})
+		require.NoError(t, err)
+	}
+	_, err := client.FetchCommittedOffset(ctx, &log_v1.FetchCommittedOffsetRequest{Group: "workers"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.CommitOffset(ctx, &log_v1.CommitOffsetRequest{Offset: 1})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.CommitOffset(ctx, &log_v1.CommitOffsetRequest{Group: "workers", Topic: "missing"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	firstOffset := func(req *log_v1.ConsumeRequest) uint64 {
+		t.Helper()
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		stream, err := client.ConsumeStream(ctx, req)
+		require.NoError(t, err)
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		return res.Record.Offset
+	}
+
+	//a group that never committed starts at the earliest record
+	require.Equal(t, uint64(0), firstOffset(&log_v1.ConsumeRequest{Group: "workers", Start: log_v1.StartPosition_START_COMMITTED}))
+
+	_, err = client.CommitOffset(ctx, &log_v1.CommitOffsetRequest{Group: "workers", Offset: 2})
+	require.NoError(t, err)
+	fetch, err := client.FetchCommittedOffset(ctx, &log_v1.FetchCommittedOffsetRequest{Group: "workers"})
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), fetch.Offset)
+	require.Equal(t, uint64(2), firstOffset(&log_v1.ConsumeRequest{Group: "workers", Start: log_v1.StartPosition_START_COMMITTED}))
+	require.Equal(t, uint64(0), firstOffset(&log_v1.ConsumeRequest{Offset: 2, Start: log_v1.StartPosition_START_EARLIEST}))
+
+	_, err = client.Consume(ctx, &log_v1.ConsumeRequest{Start: log_v1.StartPosition_START_COMMITTED})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	produced := make(chan error, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_, err := client.Produce(ctx, &log_v1.ProduceRequest{Record: &log_v1.Record{Value: []byte("new")}})
+		produced <- err
+	}()
+	require.Equal(t, uint64(3), firstOffset(&log_v1.ConsumeRequest{Start: log_v1.StartPosition_START_LATEST}))
+	require.NoError(t, <-produced)
+}
+
+func testLongPoll(t *testing.T, client log_v1.LogClient, config *Config) {
+	ctx := context.Background()
+	_, err := client.Produce(ctx, &log_v1.ProduceRequest{Record: &log_v1.Record{Value: []byte("first")}})
+	require.NoError(t, err)
+
+	//enough records are there already
+	consume, err := client.Consume(ctx, &log_v1.ConsumeRequest{MaxWaitMs: 1000})
+	require.NoError(t, err)
+	require.Len(t, consume.Records, 1)
+	require.Equal(t, []byte("first"), consume.Record.Value)
+
+	//the wait runs out with what there is
+	start := time.Now()
+	consume, err = client.Consume(ctx, &log_v1.ConsumeRequest{MaxWaitMs: 50, MinRecords: 3})
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	require.Len(t, consume.Records, 1)
+	consume, err = client.Consume(ctx, &log_v1.ConsumeRequest{Offset: 1, MaxWaitMs: 10})
+	require.NoError(t, err)
+	require.Empty(t, consume.Records)
+	require.Nil(t, consume.Record)
+
+	//records produced while the request waits complete it
+	produced := make(chan error, 1)
+	go func() {
+		for _, value := range []string{"second", "third"} {
+			time.Sleep(10 * time.Millisecond)
+			if _, err := client.Produce(ctx, &log_v1.ProduceRequest{Record: &log_v1.Record{Value: []byte(value)}}); err != nil {
+				produced <- err
+				return
+			}
+		}
+		produced <- nil
+	}()
+	consume, err = client.Consume(ctx, &log_v1.ConsumeRequest{Offset: 1, MaxWaitMs: 5000, MinRecords: 2, MinBytes: 10})
+	require.NoError(t, err)
+	require.NoError(t, <-produced)
+	require.Len(t, consume.Records, 2)
+	require.Equal(t, []byte("second"), consume.Records[0].Value)
+	require.Equal(t, []byte("third"), consume.Records[1].Value)
+
+	//once the minimum is met everything readable comes back, not just the minimum
+	for i := 0; i < 200; i++ {
+		_, err = client.Produce(ctx, &log_v1.ProduceRequest{Record: &log_v1.Record{Value: []byte("bulk")}})
+		require.NoError(t, err)
+	}
+	consume, err = client.Consume(ctx, &log_v1.ConsumeRequest{Offset: 3, MaxWaitMs: 1000})
+	require.NoError(t, err)
+	require.Len(t, consume.Records, 200)
+	require.Equal(t, uint64(202), consume.Records[199].Offset)
+
+	//a response stays under the fetch cap, and a minimum past it is met by a full response
+	big := make([]byte, 400<<10)
+	for i := 0; i < 3; i++ {
+		_, err = client.Produce(ctx, &log_v1.ProduceRequest{Record: &log_v1.Record{Value: big}})
+		require.NoError(t, err)
+	}
+	start = time.Now()
+	consume, err = client.Consume(ctx, &log_v1.ConsumeRequest{Offset: 203, MaxWaitMs: 5000, MinRecords: 10, MinBytes: 1 << 30})
+	require.NoError(t, err)
+	require.Less(t, time.Since(start), time.Second)
+	require.Len(t, consume.Records, 2)
+}
+
+func testFetch(t *testing.T, client log_v1.LogClient, config *Config) {
+	ctx := context.Background()
+	for i := 0; i < 100; i++ {
+		_, err := client.Produce(ctx, &log_v1.ProduceRequest{Record: &log_v1.Record{Value: []byte{byte(i)}}})
+		require.NoError(t, err)
+	}
+	var values []byte
+	for off := uint64(0); off < 100; {
+		fetch, err := client.Fetch(ctx, &log_v1.FetchRequest{StartOffset: off, MaxRecords: 30})
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(fetch.Records), 30)
+		for _, rec := range fetch.Records {
+			values = append(values, rec.Value...)
+		}
+		off = fetch.NextOffset
+	}
+	require.Len(t, values, 100)
+	for i, v := range values {
+		require.Equal(t, byte(i), v)
+	}
+
+	_, err := client.Fetch(ctx, &log_v1.FetchRequest{StartOffset: 100})
+	require.Equal(t, status.Code(log_v1.ErrOffsetOutOfRange{}.GRPCStatus().Err()), status.Code(err))
+}
+
 func testConsumePastLogBoundaryFails(t *testing.T, client log_v1.LogClient, config *Config) {
 	ctx := context.Background()
 	rec := &log_v1.Record{Value: []byte("hello world")}
@@ -84,6 +460,31 @@ func testConsumePastLogBoundaryFails(t *testing.T, client log_v1.LogClient, conf
 	require.Equal(t, got, want)
 }
 
+func testConsumeStreamRetention(t *testing.T, client log_v1.LogClient, config *Config) {
+	ctx := context.Background()
+	for i := 0; i < 100; i++ {
+		_, err := client.Produce(ctx, &log_v1.ProduceRequest{Record: &log_v1.Record{Value: []byte("hello world")}})
+		require.NoError(t, err)
+	}
+	clog := config.CommitLog.(*log.Log)
+	require.NoError(t, clog.Truncate(50))
+	lowest, err := clog.LowestOffset()
+	require.NoError(t, err)
+	require.NotZero(t, lowest)
+
+	//the consumer is told instead of being moved past the records it missed
+	stream, err := client.ConsumeStream(ctx, &log_v1.ConsumeRequest{Offset: lowest - 1})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, status.Code(log_v1.ErrOffsetOutOfRange{}), status.Code(err))
+
+	stream, err = client.ConsumeStream(ctx, &log_v1.ConsumeRequest{Offset: lowest})
+	require.NoError(t, err)
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, lowest, res.Record.Offset)
+}
+
 func testProduceStream(t *testing.T, client log_v1.LogClient, config *Config) {
 	ctx := context.Background()
 
@@ -130,3 +531,61 @@ func testProduceConsumeStream(t *testing.T, client log_v1.LogClient, config *Con
 	}
 
 }
+
+// countingLog counts reads to tell a waiting consumer from a spinning one
+type countingLog struct {
+	CommitLog
+	reads atomic.Int64
+}
+
+func (l *countingLog) Read(off uint64) (*log_v1.Record, error) {
+	l.reads.Add(1)
+	return l.CommitLog.Read(off)
+}
+
+func TestConsumeStreamWaitsForRecords(t *testing.T) {
+	var clog *countingLog
+	client, _, teardown := setupTest(t, func(c *Config) {
+		clog = &countingLog{CommitLog: c.CommitLog}
+		c.CommitLog = clog
+	})
+	defer teardown()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := client.ConsumeStream(ctx, &log_v1.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+	require.LessOrEqual(t, clog.reads.Load(), int64(2), "an idle stream must not poll the log")
+
+	_, err = client.Produce(ctx, &log_v1.ProduceRequest{Record: &log_v1.Record{Value: []byte("finally")}})
+	require.NoError(t, err)
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, []byte("finally"), res.Record.Value)
+}
+
+func testGetServers(t *testing.T, client log_v1.LogClient, config *Config) {
+	ctx := context.Background()
+	_, err := client.GetServers(ctx, &log_v1.GetServersRequest{})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	//the getter is part of the config before a server starts serving from it
+	servers := []*log_v1.Server{{Id: "0", RpcAddr: "127.0.0.1:8400", Writable: true}, {Id: "1", RpcAddr: "127.0.0.1:8401"}}
+	client, _, teardown := setupTest(t, func(c *Config) {
+		c.ServerGetter = serverList(servers)
+	})
+	defer teardown()
+	res, err := client.GetServers(ctx, &log_v1.GetServersRequest{})
+	require.NoError(t, err)
+	require.Len(t, res.Servers, 2)
+	for i, srv := range res.Servers {
+		require.True(t, proto.Equal(servers[i], srv))
+	}
+}
+
+type serverList []*log_v1.Server
+
+func (l serverList) GetServers() ([]*log_v1.Server, error) {
+	return l, nil
+}
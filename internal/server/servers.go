@@ -0,0 +1,26 @@
+package server
+
+import (
+	"context"
+	log_v1 "github.com/mishamolnar/proglog/api/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var errNoServers = status.Error(codes.FailedPrecondition, "server discovery is not enabled on this server")
+
+// ServerGetter lists the servers clients can balance over, log.DistributedLog is one
+type ServerGetter interface {
+	GetServers() ([]*log_v1.Server, error)
+}
+
+func (s *grpcServer) GetServers(ctx context.Context, req *log_v1.GetServersRequest) (*log_v1.GetServersResponse, error) {
+	if s.ServerGetter == nil {
+		return nil, errNoServers
+	}
+	servers, err := s.ServerGetter.GetServers()
+	if err != nil {
+		return nil, err
+	}
+	return &log_v1.GetServersResponse{Servers: servers}, nil
+}
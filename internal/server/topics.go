@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	log_v1 "github.com/mishamolnar/proglog/api/v1"
+	"github.com/mishamolnar/proglog/internal/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+var errNoTopics = status.Error(codes.FailedPrecondition, "topics are not enabled on this server")
+
+// commitLog returns the log serving a topic's partition, the default log when topic is empty
+func (c *Config) commitLog(topic string, partition uint32) (CommitLog, error) {
+	if topic == "" {
+		if partition != 0 {
+			return nil, log_v1.ErrPartitionNotFound{Partition: partition}
+		}
+		return c.CommitLog, nil
+	}
+	if c.Topics == nil {
+		return nil, log_v1.ErrTopicNotFound{Name: topic}
+	}
+	t, err := c.Topics.Topic(topic)
+	if err != nil {
+		return nil, err
+	}
+	l, err := t.Partition(partition)
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// errTombstonePartition rejects tombstones the partitioner would place away from the records of their key
+var errTombstonePartition = status.Error(codes.InvalidArgument, "the partitioner does not keep keys together, a tombstone needs a partition")
+
+// produceLog is commitLog for writes, the partitioner picks the partition from key unless one is given.
+// Tombstones take the same path as the keyed records they delete, so with a partitioner that spreads a key over
+// the partitions they have to name theirs
+func (c *Config) produceLog(topic string, key []byte, tombstone bool, partition *uint32) (CommitLog, uint32, error) {
+	if partition != nil {
+		clog, err := c.commitLog(topic, *partition)
+		return clog, *partition, err
+	}
+	if topic == "" || c.Topics == nil {
+		clog, err := c.commitLog(topic, 0)
+		return clog, 0, err
+	}
+	t, err := c.Topics.Topic(topic)
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, spreads := c.Partitioner.(*log.RoundRobinPartitioner); tombstone && spreads && t.Partitions() > 1 {
+		return nil, 0, errTombstonePartition
+	}
+	p := c.Partitioner.Partition(key, t.Partitions())
+	l, err := t.Partition(p)
+	if err != nil {
+		return nil, 0, err
+	}
+	return l, p, nil
+}
+
+func (s *grpcServer) CreateTopic(ctx context.Context, req *log_v1.CreateTopicRequest) (*log_v1.CreateTopicResponse, error) {
+	if s.Topics == nil {
+		return nil, errNoTopics
+	}
+	if err := s.Topics.CreateTopic(req.Name, topicConfig(req.Config)); err != nil {
+		return nil, err
+	}
+	return &log_v1.CreateTopicResponse{}, nil
+}
+
+func (s *grpcServer) DeleteTopic(ctx context.Context, req *log_v1.DeleteTopicRequest) (*log_v1.DeleteTopicResponse, error) {
+	if s.Topics == nil {
+		return nil, errNoTopics
+	}
+	if err := s.Topics.DeleteTopic(req.Name); err != nil {
+		return nil, err
+	}
+	return &log_v1.DeleteTopicResponse{}, nil
+}
+
+func (s *grpcServer) ListTopics(ctx context.Context, req *log_v1.ListTopicsRequest) (*log_v1.ListTopicsResponse, error) {
+	res := &log_v1.ListTopicsResponse{}
+	if s.Topics == nil {
+		return res, nil
+	}
+	for _, t := range s.Topics.ListTopics() {
+		res.Topics = append(res.Topics, &log_v1.Topic{Name: t.Name, Config: topicConfigProto(t.Config)})
+	}
+	return res, nil
+}
+
+func topicConfig(c *log_v1.TopicConfig) log.TopicConfig {
+	return log.TopicConfig{
+		MaxStoreBytes:     c.GetMaxStoreBytes(),
+		MaxIndexBytes:     c.GetMaxIndexBytes(),
+		RetentionMaxAge:   time.Duration(c.GetRetentionMs()) * time.Millisecond,
+		RetentionMaxBytes: c.GetRetentionBytes(),
+		Partitions:        c.GetPartitions(),
+	}
+}
+
+func topicConfigProto(c log.TopicConfig) *log_v1.TopicConfig {
+	return &log_v1.TopicConfig{
+		MaxStoreBytes:  c.MaxStoreBytes,
+		MaxIndexBytes:  c.MaxIndexBytes,
+		RetentionMs:    c.RetentionMaxAge.Milliseconds(),
+		RetentionBytes: c.RetentionMaxBytes,
+		Partitions:     c.Partitions,
+	}
+}